@@ -1,20 +1,48 @@
 package engine
 
 import (
-	"encoding/json"
+	"context"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type JokerEngine struct {
 	port        int
 	middlewares []Middleware
+	renderers   []rendererEntry
 	Cache       *jokerCache
+	Routes      *jokerRoutes
+
+	jsonCodec        JSONCodec
+	jsonNoEscapeHTML bool
+	jsonPrettyQuery  string
+
+	templates *jokerTemplates
+	devMode   bool
+
+	maxBodySize      int64
+	requestTimeout   time.Duration
+	multipartMemory  int64
+	sseKeepAlive     time.Duration
+	websocketOptions WebSocketOptions
+	errorHandler     ErrorHandler
+	principalFunc    func(ctx *JokerContex) Principal
+	cookieKeyring    *Keyring
+	serverHeader     *string
+	trustedProxies   []*net.IPNet
+
+	server       *http.Server
+	shutdownMu   sync.Mutex
+	shutdownChan chan struct{}
 }
 
 func NewEngine() *JokerEngine {
@@ -29,6 +57,11 @@ func (jokerEngine *JokerEngine) Init() {
 	// Initialize the cache
 	jokerEngine.Cache = &jokerCache{}
 	jokerEngine.Cache.init()
+	if jokerEngine.Routes == nil {
+		jokerEngine.Routes = &jokerRoutes{}
+	}
+	// Register the default renderers for content negotiation
+	jokerEngine.initRenderers()
 }
 
 func (jokerEngine *JokerEngine) SetPort(port int) {
@@ -47,7 +80,7 @@ func (jokerEngine *JokerEngine) UseStaticFiles(baseRoot string, target string) {
 	}
 	// Handle the static file server
 	http.HandleFunc(target, func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Server", "JokerHttp")
+		jokerEngine.setServerHeader(w.Header())
 		w.Header().Set("X-Static-File", "JokerHttp")
 		w.Header().Set("Cache-Control", "cache, max-age=3600")
 		if strings.HasPrefix(r.URL.Path, target) {
@@ -59,211 +92,170 @@ func (jokerEngine *JokerEngine) UseStaticFiles(baseRoot string, target string) {
 	})
 }
 
-func (jokerEngine *JokerEngine) Map(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+func (jokerEngine *JokerEngine) Map(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "*")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount := len(jokerEngine.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount+1),
-			index:            -1,
-			maxIndex:         middlewareCount + 1,
-			aborted:          false,
-		}
-		copy(ctx.MiddlewareChains, jokerEngine.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			params := r.URL.Query()
-			status, response := handle(r, params, func(key, value string) {
-				w.Header().Set(key, value)
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
 			})
-			if response == nil {
-				w.WriteHeader(status)
-				return
-			}
-			jsonresult, err := json.Marshal(response)
-			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Server", "JokerHttp")
-			w.WriteHeader(status)
-			w.Write(jsonresult)
-		}
-
-		ctx.MiddlewareChains[middlewareCount] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			jokerEngine.writeResponse(ctx, status, response)
 		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
 	})
+	return route
 }
 
-func (jokerEngine *JokerEngine) MapGet(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+func (jokerEngine *JokerEngine) MapGet(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "GET")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount := len(jokerEngine.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount+1),
-			index:            -1,
-			maxIndex:         middlewareCount + 1,
-			aborted:          false,
-		}
-		copy(ctx.MiddlewareChains, jokerEngine.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			if r.Method != http.MethodGet {
-				w.WriteHeader(405)
+			if ctx.Request.Method != http.MethodGet {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
 				return
 			}
-			params := r.URL.Query()
-			status, response := handle(r, params, func(key, value string) {
-				w.Header().Set(key, value)
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
 			})
-			if response == nil {
-				w.WriteHeader(status)
-				return
-			}
-			jsonresult, err := json.Marshal(response)
-			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Server", "JokerHttp")
-			w.WriteHeader(status)
-			w.Write(jsonresult)
-		}
-
-		ctx.MiddlewareChains[middlewareCount] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			jokerEngine.writeResponse(ctx, status, response)
 		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
 	})
+	return route
 }
 
-func (jokerEngine *JokerEngine) MapPost(pattern string, handle func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+func (jokerEngine *JokerEngine) MapPost(pattern string, handle func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "POST")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount := len(jokerEngine.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount+1),
-			index:            -1,
-			maxIndex:         middlewareCount + 1,
-			aborted:          false,
-		}
-		copy(ctx.MiddlewareChains, jokerEngine.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			if r.Method != http.MethodPost {
-				w.WriteHeader(405)
+			if ctx.Request.Method != http.MethodPost {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
 				return
 			}
-			body := make([]byte, r.ContentLength)
-			_, err := r.Body.Read(body)
-			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
+			body, ok := jokerEngine.readBody(ctx)
+			if !ok {
 				return
 			}
-			defer r.Body.Close()
-			params := r.URL.Query()
-			status, response := handle(r, body, params, func(key, value string) {
-				w.Header().Set(key, value)
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, body, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
 			})
-			if response == nil {
-				w.WriteHeader(status)
+			jokerEngine.writeResponse(ctx, status, response)
+		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
+	})
+	return route
+}
+
+// MapPostStream hands the decoded, size-limited body to the handler as a stream instead of reading it first
+func (jokerEngine *JokerEngine) MapPostStream(pattern string, handle func(request *http.Request, body io.Reader, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "POST")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			if ctx.Request.Method != http.MethodPost {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
 				return
 			}
-			jsonResult, err := json.Marshal(response)
+			body, err := jokerEngine.requestBody(ctx.ResponseWriter, ctx.Request)
 			if err != nil {
-				log.Println("[Error]: Failed to marshal response:", err)
-				w.WriteHeader(500)
+				ctx.HandleError(bodyErrorStatus(err), err)
 				return
 			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Server", "JokerHttp")
-			w.WriteHeader(status)
-			w.Write(jsonResult)
-		}
-
-		ctx.MiddlewareChains[middlewareCount] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			defer body.Close()
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, body, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
+			})
+			jokerEngine.writeResponse(ctx, status, response)
 		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
 	})
+	return route
 }
 
 func (jokerEngine *JokerEngine) Run() {
-	http.ListenAndServe(":"+strconv.Itoa(jokerEngine.port), nil)
+	jokerEngine.RunWithAddr(":" + strconv.Itoa(jokerEngine.port))
 }
 
 func (jokerEngine *JokerEngine) RunWithAddr(addr string) {
-	http.ListenAndServe(addr, nil)
+	jokerEngine.shutdownMu.Lock()
+	jokerEngine.server = &http.Server{Addr: addr}
+	jokerEngine.shutdownMu.Unlock()
+	jokerEngine.server.ListenAndServe()
+}
+
+// Shutdown stops the server gracefully and closes open event streams
+func (jokerEngine *JokerEngine) Shutdown(ctx context.Context) error {
+	jokerEngine.shutdownSignal()
+	jokerEngine.shutdownMu.Lock()
+	select {
+	case <-jokerEngine.shutdownChan:
+	default:
+		close(jokerEngine.shutdownChan)
+	}
+	server := jokerEngine.server
+	jokerEngine.shutdownMu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
 
-func (jokerEngine *JokerEngine) MapRedirect(pattern string, target string) {
+// shutdownSignal is closed once Shutdown has been called
+func (jokerEngine *JokerEngine) shutdownSignal() <-chan struct{} {
+	jokerEngine.shutdownMu.Lock()
+	defer jokerEngine.shutdownMu.Unlock()
+	if jokerEngine.shutdownChan == nil {
+		jokerEngine.shutdownChan = make(chan struct{})
+	}
+	return jokerEngine.shutdownChan
+}
+
+func (jokerEngine *JokerEngine) MapRedirect(pattern string, target string) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "*")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount := len(jokerEngine.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount+1),
-			index:            -1,
-			maxIndex:         middlewareCount + 1,
-			aborted:          false,
-		}
-		copy(ctx.MiddlewareChains, jokerEngine.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			http.Redirect(w, r, target, http.StatusFound)
-		}
-		ctx.MiddlewareChains[middlewareCount] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			http.Redirect(ctx.ResponseWriter, ctx.Request, target, http.StatusFound)
 		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
 	})
+	return route
 }
 
-func newProxy(targetHost string) (*httputil.ReverseProxy, error) {
+func (jokerEngine *JokerEngine) newProxy(targetHost string, pattern string) (*httputil.ReverseProxy, error) {
 	url, err := url.Parse(targetHost)
 	if err != nil {
 		return nil, err
 	}
+	// The outgoing request uses the incoming request's context, so deadlines and cancellation carry over
 	proxy := httputil.NewSingleHostReverseProxy(url)
-	proxy.ModifyResponse = modifyResponse()
+	proxy.ModifyResponse = modifyResponse(jokerEngine)
+	proxy.ErrorHandler = proxyErrorHandler(pattern)
 	return proxy, nil
 }
 
-func modifyResponse() func(*http.Response) error {
+func modifyResponse(jokerEngine *JokerEngine) func(*http.Response) error {
 	return func(resp *http.Response) error {
 		resp.Header.Set("X-Proxy", "JokerHttp")
-		resp.Header.Set("Server", "JokerHttp")
+		jokerEngine.setServerHeader(resp.Header)
 		return nil
 	}
 }
 
-func (jokerEngine *JokerEngine) MapReverseProxy(pattern string, target string) {
+func (jokerEngine *JokerEngine) MapReverseProxy(pattern string, target string) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "*")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount := len(jokerEngine.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount+1),
-			index:            -1,
-			maxIndex:         middlewareCount + 1,
-			aborted:          false,
-		}
-		copy(ctx.MiddlewareChains, jokerEngine.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			proxy, err := newProxy(target)
+			proxy, err := jokerEngine.newProxy(target, pattern)
 			if err != nil {
-				ctx.ResponseWriter.WriteHeader(500)
+				ctx.HandleError(http.StatusInternalServerError, err)
 				return
 			}
 			proxy.ServeHTTP(ctx.ResponseWriter, ctx.Request)
 		}
-		ctx.MiddlewareChains[middlewareCount] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
-		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
 	})
+	return route
 }
@@ -0,0 +1,256 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errUnauthorized = NewHTTPError(http.StatusUnauthorized, "unauthorized", "")
+
+type BasicAuthOptions struct {
+	// Realm is sent in the WWW-Authenticate challenge, "Restricted" when empty
+	Realm string
+	// Validate checks the credentials and returns the principal stored in the context
+	Validate func(username, password string) (principal interface{}, ok bool)
+	// ContextKey is where the principal is stored with ctx.Set, "user" when empty
+	ContextKey string
+}
+
+// BasicAuth requires HTTP Basic credentials accepted by options.Validate
+func BasicAuth(options BasicAuthOptions) Middleware {
+	if options.Validate == nil {
+		panic("[Error]:BasicAuth needs a Validate function")
+	}
+	if options.Realm == "" {
+		options.Realm = "Restricted"
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "user"
+	}
+	challenge := "Basic realm=" + strconv.Quote(options.Realm) + `, charset="UTF-8"`
+	return func(ctx *JokerContex) {
+		username, password, ok := ctx.Request.BasicAuth()
+		var principal interface{}
+		if ok {
+			principal, ok = options.Validate(username, password)
+		}
+		if !ok {
+			ctx.ResponseWriter.Header().Set("WWW-Authenticate", challenge)
+			ctx.AbortWithError(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		ctx.Set(options.ContextKey, principal)
+		ctx.Next()
+	}
+}
+
+// BasicAuthAccounts validates against username to password hashes made with HashPassword;
+// the username becomes the principal. Each check costs one PBKDF2 run (over 100ms of CPU), so
+// accepted credentials are remembered for five minutes; failed attempts always pay the full cost.
+func BasicAuthAccounts(accounts map[string]string) func(username, password string) (interface{}, bool) {
+	// Unknown users are checked against a dummy hash so they take as long as wrong passwords
+	dummy, err := HashPassword("")
+	if err != nil {
+		panic("[Error]:BasicAuthAccounts: " + err.Error())
+	}
+	verified := newVerifiedCache()
+	return func(username, password string) (interface{}, bool) {
+		if verified.has(username, password) {
+			return username, true
+		}
+		hash, found := accounts[username]
+		if !found {
+			hash = dummy
+		}
+		ok := VerifyPassword(password, hash) && found
+		if ok {
+			verified.add(username, password)
+		}
+		return username, ok
+	}
+}
+
+// verifiedTTL is how long a successful Basic auth check is reused before the password is hashed again
+const verifiedTTL = 5 * time.Minute
+
+// verifiedCache holds credentials that passed VerifyPassword, keyed by an HMAC under a random
+// per-instance key so the map never holds anything that could be used to recover a password
+type verifiedCache struct {
+	key     []byte
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func newVerifiedCache() *verifiedCache {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("[Error]:BasicAuthAccounts: " + err.Error())
+	}
+	return &verifiedCache{key: key, entries: make(map[string]time.Time)}
+}
+
+func (cache *verifiedCache) digest(username, password string) string {
+	mac := hmac.New(sha256.New, cache.key)
+	mac.Write([]byte(strconv.Itoa(len(username)) + ":" + username + ":" + password))
+	return string(mac.Sum(nil))
+}
+
+func (cache *verifiedCache) has(username, password string) bool {
+	digest := cache.digest(username, password)
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	expires, found := cache.entries[digest]
+	return found && time.Now().Before(expires)
+}
+
+func (cache *verifiedCache) add(username, password string) {
+	digest := cache.digest(username, password)
+	now := time.Now()
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for d, expires := range cache.entries {
+		if !now.Before(expires) {
+			delete(cache.entries, d)
+		}
+	}
+	cache.entries[digest] = now.Add(verifiedTTL)
+}
+
+const (
+	passwordIterations    = 600000
+	maxPasswordIterations = 10000000
+)
+
+// HashPassword returns a salted PBKDF2-SHA256 hash of password, "pbkdf2-sha256$<iterations>$<salt>$<key>",
+// for BasicAuthAccounts or VerifyPassword. It is deliberately slow.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return "pbkdf2-sha256$" + strconv.Itoa(passwordIterations) + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key), nil
+}
+
+// VerifyPassword reports whether password matches a hash made with HashPassword
+func VerifyPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 || iterations > maxPasswordIterations {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// HashSecret returns the hex SHA-256 of an API key, for storing it without the plain text.
+// It is fast and unsalted, so it only suits random high-entropy keys; use HashPassword for passwords.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type APIKeyOptions struct {
+	// Header, Query and Cookie name where the key is looked for, in that order; X-API-Key header when all are empty
+	Header string
+	Query  string
+	Cookie string
+	// Validate checks the key and returns the principal stored in the context
+	Validate func(key string) (principal interface{}, ok bool)
+	// Realm is sent in the WWW-Authenticate challenge, "Restricted" when empty
+	Realm string
+	// ContextKey is where the principal is stored with ctx.Set, "user" when empty
+	ContextKey string
+}
+
+// APIKey requires an API key accepted by options.Validate
+func APIKey(options APIKeyOptions) Middleware {
+	if options.Validate == nil {
+		panic("[Error]:APIKey needs a Validate function")
+	}
+	if options.Header == "" && options.Query == "" && options.Cookie == "" {
+		options.Header = "X-API-Key"
+	}
+	if options.Realm == "" {
+		options.Realm = "Restricted"
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "user"
+	}
+	challenge := "APIKey realm=" + strconv.Quote(options.Realm)
+	return func(ctx *JokerContex) {
+		key := options.lookup(ctx.Request)
+		var principal interface{}
+		ok := key != ""
+		if ok {
+			principal, ok = options.Validate(key)
+		}
+		if !ok {
+			ctx.ResponseWriter.Header().Set("WWW-Authenticate", challenge)
+			ctx.AbortWithError(http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		ctx.Set(options.ContextKey, principal)
+		ctx.Next()
+	}
+}
+
+func (options APIKeyOptions) lookup(r *http.Request) string {
+	if options.Header != "" {
+		if key := r.Header.Get(options.Header); key != "" {
+			return key
+		}
+	}
+	if options.Query != "" {
+		if key := r.URL.Query().Get(options.Query); key != "" {
+			return key
+		}
+	}
+	if options.Cookie != "" {
+		if cookie, err := r.Cookie(options.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// HashedAPIKeys validates against SHA-256 key hashes made with HashSecret, mapped to their principal.
+// Every stored hash is compared in constant time.
+func HashedAPIKeys(keys map[string]interface{}) func(key string) (interface{}, bool) {
+	return func(key string) (interface{}, bool) {
+		hash := []byte(HashSecret(key))
+		var principal interface{}
+		found := false
+		for stored, p := range keys {
+			if subtle.ConstantTimeCompare(hash, []byte(stored)) == 1 {
+				principal, found = p, true
+			}
+		}
+		return principal, found
+	}
+}
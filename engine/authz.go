@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+)
+
+var errForbidden = NewHTTPError(http.StatusForbidden, "forbidden", "")
+
+// Principal is implemented by user types that carry roles and permissions; Claims implements it
+type Principal interface {
+	Roles() []string
+	Permissions() []string
+}
+
+// Policy is an attribute-based check; it can read path values with ctx.Request.PathValue
+// and load the resource itself. principal is nil for anonymous requests.
+type Policy func(ctx *JokerContex, principal Principal) bool
+
+type requirement struct {
+	roles       []string
+	permissions []string
+	policy      string
+	check       Policy
+}
+
+// SetPrincipalFunc overrides how the principal is found, by default the "user" value
+// if it implements Principal, otherwise the JWT "claims"
+func (jokerEngine *JokerEngine) SetPrincipalFunc(principal func(ctx *JokerContex) Principal) {
+	jokerEngine.principalFunc = principal
+}
+
+// Principal returns the authenticated principal, or nil
+func (ctx *JokerContex) Principal() Principal {
+	if ctx.engine.principalFunc != nil {
+		return ctx.engine.principalFunc(ctx)
+	}
+	if principal, ok := Value[Principal](ctx, "user"); ok {
+		return principal
+	}
+	if claims, ok := Value[Claims](ctx, "claims"); ok {
+		return claims
+	}
+	return nil
+}
+
+// Roles reads the "roles" claim, given as an array or a single string
+func (c Claims) Roles() []string {
+	return c.strings("roles")
+}
+
+// Permissions reads the "permissions" claim and the space separated OAuth "scope"
+func (c Claims) Permissions() []string {
+	permissions := c.strings("permissions")
+	if scope, ok := c["scope"].(string); ok {
+		permissions = append(permissions, strings.Fields(scope)...)
+	}
+	return permissions
+}
+
+func (c Claims) strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func containsAll(have []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (req requirement) allows(ctx *JokerContex, principal Principal) bool {
+	if len(req.roles) > 0 || len(req.permissions) > 0 {
+		if principal == nil || !containsAll(principal.Roles(), req.roles) || !containsAll(principal.Permissions(), req.permissions) {
+			return false
+		}
+	}
+	return req.check == nil || req.check(ctx, principal)
+}
+
+// authorizeRequirements answers 401 for anonymous and 403 for authenticated requests that are denied
+func authorizeRequirements(ctx *JokerContex, requirements []requirement) bool {
+	if len(requirements) == 0 {
+		return true
+	}
+	principal := ctx.Principal()
+	for _, req := range requirements {
+		if !req.allows(ctx, principal) {
+			if principal == nil {
+				ctx.AbortWithError(http.StatusUnauthorized, errUnauthorized)
+			} else {
+				ctx.AbortWithError(http.StatusForbidden, errForbidden)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (route *Route) authorize(ctx *JokerContex) {
+	if authorizeRequirements(ctx, route.requirements) {
+		ctx.Next()
+	}
+}
+
+// Require makes the route require all of roles
+func (route *Route) Require(roles ...string) *Route {
+	route.requirements = append(route.requirements, requirement{roles: roles})
+	return route
+}
+
+// RequirePermission makes the route require all of permissions
+func (route *Route) RequirePermission(permissions ...string) *Route {
+	route.requirements = append(route.requirements, requirement{permissions: permissions})
+	return route
+}
+
+// RequirePolicy makes the route require policy; name is what Routes.Introspect reports
+func (route *Route) RequirePolicy(name string, policy Policy) *Route {
+	route.requirements = append(route.requirements, requirement{policy: name, check: policy})
+	return route
+}
+
+func (router *JokerRouter) require(req requirement) {
+	router.requirements = append(router.requirements, req)
+	router.Use(func(ctx *JokerContex) {
+		if authorizeRequirements(ctx, []requirement{req}) {
+			ctx.Next()
+		}
+	})
+}
+
+// Require makes every route of the group require all of roles; it runs in Use order,
+// so authentication middleware must be added first
+func (router *JokerRouter) Require(roles ...string) {
+	router.require(requirement{roles: roles})
+}
+
+// RequirePermission makes every route of the group require all of permissions
+func (router *JokerRouter) RequirePermission(permissions ...string) {
+	router.require(requirement{permissions: permissions})
+}
+
+// RequirePolicy makes every route of the group require policy
+func (router *JokerRouter) RequirePolicy(name string, policy Policy) {
+	router.require(requirement{policy: name, check: policy})
+}
@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultMaxBodySize = 32 << 20
+
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// SetMaxBodySize limits request bodies, after decoding, to size bytes; larger bodies get 413
+func (jokerEngine *JokerEngine) SetMaxBodySize(size int64) {
+	jokerEngine.maxBodySize = size
+}
+
+func (jokerEngine *JokerEngine) bodyLimit() int64 {
+	if jokerEngine.maxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+	return jokerEngine.maxBodySize
+}
+
+// limitedReader fails with *http.MaxBytesError instead of silently truncating
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &http.MaxBytesError{Limit: l.limit}
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n + int(l.remaining), &http.MaxBytesError{Limit: l.limit}
+	}
+	return n, err
+}
+
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if closeErr := b.closers[i].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// requestBody returns the request body with Content-Encoding removed and the size limit applied
+func (jokerEngine *JokerEngine) requestBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	limit := jokerEngine.bodyLimit()
+	raw := http.MaxBytesReader(w, r.Body, limit)
+	body := &decodedBody{Reader: raw, closers: []io.Closer{raw}}
+	encodings := strings.Split(r.Header.Get("Content-Encoding"), ",")
+	// Codings are listed in the order they were applied, so undo them from the end
+	for i := len(encodings) - 1; i >= 0; i-- {
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			reader, err := gzip.NewReader(body.Reader)
+			if err != nil {
+				body.Close()
+				return nil, err
+			}
+			body.Reader = reader
+			body.closers = append(body.closers, reader)
+		case "deflate":
+			reader, err := newDeflateReader(body.Reader)
+			if err != nil {
+				body.Close()
+				return nil, err
+			}
+			body.Reader = reader
+			body.closers = append(body.closers, reader)
+		default:
+			body.Close()
+			return nil, errUnsupportedEncoding
+		}
+	}
+	if len(body.closers) > 1 {
+		body.Reader = &limitedReader{reader: body.Reader, remaining: limit, limit: limit}
+	}
+	return body, nil
+}
+
+// newDeflateReader accepts zlib-wrapped data as the spec says, and raw deflate as some clients send
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// bodyErrorStatus maps a body read error to the status sent to the client
+func bodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// readBody reads the whole request body, rendering the error when that fails
+func (jokerEngine *JokerEngine) readBody(ctx *JokerContex) ([]byte, bool) {
+	body, err := jokerEngine.requestBody(ctx.ResponseWriter, ctx.Request)
+	if err == nil {
+		defer body.Close()
+		var data []byte
+		if data, err = io.ReadAll(body); err == nil {
+			return data, true
+		}
+	}
+	ctx.HandleError(bodyErrorStatus(err), err)
+	return nil, false
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -10,6 +11,17 @@ type jokerCache struct {
 	cacheMap         map[string]*cacheItem
 	checkTime_second int64
 	mu               sync.RWMutex
+	loads            map[string]*cacheLoad
+	loadsMu          sync.Mutex
+}
+
+// cacheLoad is one loader call shared by every caller asking for the same key
+type cacheLoad struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
 }
 
 type cacheItem struct {
@@ -94,3 +106,53 @@ func (c *jokerCache) Clear() {
 		delete(c.cacheMap, key)
 	}
 }
+
+// GetOrLoad returns the cached value or calls loader once for all concurrent callers of the key.
+// A caller whose ctx ends stops waiting; the loader's context is cancelled when no caller is left.
+func (c *jokerCache) GetOrLoad(ctx context.Context, key string, expiresAt int64, loader func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if value, ok := c.TryGet(key); ok {
+		return value, nil
+	}
+	c.loadsMu.Lock()
+	if c.loads == nil {
+		c.loads = make(map[string]*cacheLoad)
+	}
+	load, ok := c.loads[key]
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		load = &cacheLoad{done: make(chan struct{}), cancel: cancel}
+		c.loads[key] = load
+		go func() {
+			defer cancel()
+			load.value, load.err = loader(loadCtx)
+			if load.err == nil {
+				c.Set(key, load.value, expiresAt)
+			}
+			c.loadsMu.Lock()
+			if c.loads[key] == load {
+				delete(c.loads, key)
+			}
+			c.loadsMu.Unlock()
+			close(load.done)
+		}()
+	}
+	load.waiters++
+	c.loadsMu.Unlock()
+
+	select {
+	case <-load.done:
+		return load.value, load.err
+	case <-ctx.Done():
+		c.loadsMu.Lock()
+		load.waiters--
+		if load.waiters == 0 {
+			// Nobody wants the result any more; later callers start a fresh load
+			load.cancel()
+			if c.loads[key] == load {
+				delete(c.loads, key)
+			}
+		}
+		c.loadsMu.Unlock()
+		return nil, ctx.Err()
+	}
+}
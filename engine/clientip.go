@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// SetTrustedProxies lists the proxies, as CIDRs or single addresses, whose forwarding headers are believed.
+// Without it Forwarded, X-Forwarded-For and X-Real-IP are ignored.
+func (jokerEngine *JokerEngine) SetTrustedProxies(proxies ...string) error {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: proxy}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+	jokerEngine.trustedProxies = networks
+	return nil
+}
+
+func (jokerEngine *JokerEngine) trustedProxy(ip net.IP) bool {
+	for _, network := range jokerEngine.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type remoteInfo struct {
+	ip     string
+	scheme string
+	host   string
+}
+
+// forwardedHop is one proxy's view of the request it received
+type forwardedHop struct {
+	node  string
+	proto string
+	host  string
+}
+
+// ClientIP is the address of the client, seen through trusted proxies
+func (ctx *JokerContex) ClientIP() string {
+	return ctx.remoteInfo().ip
+}
+
+// Scheme is "https" or "http" as the client used it, seen through trusted proxies
+func (ctx *JokerContex) Scheme() string {
+	return ctx.remoteInfo().scheme
+}
+
+// Host is the host the client asked for, seen through trusted proxies
+func (ctx *JokerContex) Host() string {
+	return ctx.remoteInfo().host
+}
+
+func (ctx *JokerContex) remoteInfo() *remoteInfo {
+	if ctx.remote == nil {
+		ctx.remote = ctx.engine.resolveRemote(ctx.Request)
+	}
+	return ctx.remote
+}
+
+// resolveRemote walks the forwarding chain from the nearest hop back, stopping at the first untrusted address,
+// so a client cannot pose as someone else by sending the headers itself
+func (jokerEngine *JokerEngine) resolveRemote(r *http.Request) *remoteInfo {
+	info := &remoteInfo{ip: r.RemoteAddr, scheme: "http", host: r.Host}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		info.ip = host
+	}
+	if r.TLS != nil {
+		info.scheme = "https"
+	}
+	peer := net.ParseIP(info.ip)
+	if peer == nil || !jokerEngine.trustedProxy(peer) {
+		return info
+	}
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		ip := net.ParseIP(parseForwardedNode(hop.node))
+		if ip == nil {
+			// "unknown" or an obfuscated identifier, the last trusted proxy is as far as we can see
+			break
+		}
+		info.ip = ip.String()
+		if proto := strings.ToLower(hop.proto); proto == "http" || proto == "https" {
+			info.scheme = proto
+		}
+		if validForwardedHost(hop.host) {
+			info.host = hop.host
+		}
+		if !jokerEngine.trustedProxy(ip) {
+			break
+		}
+	}
+	return info
+}
+
+// forwardedHops reads Forwarded, falling back to X-Forwarded-For/-Proto/-Host and then X-Real-IP
+func forwardedHops(header http.Header) []forwardedHop {
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		return parseForwarded(values)
+	}
+	nodes := headerList(header, "X-Forwarded-For")
+	if len(nodes) == 0 {
+		if realIP := strings.TrimSpace(header.Get("X-Real-IP")); realIP != "" {
+			nodes = []string{realIP}
+		}
+	}
+	protos := headerList(header, "X-Forwarded-Proto")
+	hosts := headerList(header, "X-Forwarded-Host")
+	hops := make([]forwardedHop, len(nodes))
+	for i, node := range nodes {
+		hops[i] = forwardedHop{node: node, proto: listValue(protos, i, len(nodes)), host: listValue(hosts, i, len(nodes))}
+	}
+	return hops
+}
+
+func headerList(header http.Header, name string) []string {
+	var list []string
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// listValue lines a proto or host list up with the address list; a single value applies to every hop
+func listValue(list []string, i, n int) string {
+	switch len(list) {
+	case n:
+		return list[i]
+	case 1:
+		return list[0]
+	}
+	return ""
+}
+
+// parseForwarded reads RFC 7239 elements such as `for="[2001:db8::1]:4711";proto=https;host=example.com`
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			var hop forwardedHop
+			for _, pair := range splitQuoted(element, ';') {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					hop.node = val
+				case "proto":
+					hop.proto = val
+				case "host":
+					hop.host = val
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits on sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseForwardedNode strips the port from "1.2.3.4:80" or "[::1]:80"
+func parseForwardedNode(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+func validForwardedHost(host string) bool {
+	return host != "" && len(host) <= 255 && !strings.ContainsAny(host, " \t/\\@?#\"")
+}
@@ -0,0 +1,247 @@
+package engine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxCookieSize is the whole Set-Cookie value browsers are required to store
+const maxCookieSize = 4096
+
+var (
+	ErrCookieTooLarge = errors.New("cookie larger than 4096 bytes")
+	ErrCookieInvalid  = errors.New("cookie signature or encryption invalid")
+	ErrNoKeyring      = errors.New("no cookie keyring set, see SetCookieKeyring")
+)
+
+type keyringKey struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// Keyring signs and encrypts with its first key and accepts all of them, so keys can be rotated
+// by putting the new secret first and dropping the old one once its cookies have expired
+type Keyring struct {
+	keys []keyringKey
+}
+
+func NewKeyring(secrets ...[]byte) *Keyring {
+	if len(secrets) == 0 {
+		panic("[Error]:NewKeyring needs at least one secret")
+	}
+	keyring := &Keyring{}
+	for _, secret := range secrets {
+		block, _ := aes.NewCipher(deriveKey(secret, "joker-cookie-encrypt"))
+		aead, _ := cipher.NewGCM(block)
+		keyring.keys = append(keyring.keys, keyringKey{sign: deriveKey(secret, "joker-cookie-sign"), aead: aead})
+	}
+	return keyring
+}
+
+// deriveKey gives every purpose its own key so one secret is never used twice
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func (key keyringKey) mac(name string, value []byte) []byte {
+	mac := hmac.New(sha256.New, key.sign)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// Sign returns value with an HMAC bound to the cookie name, so it cannot be moved to another cookie
+func (keyring *Keyring) Sign(name string, value []byte) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(value) + "." + enc.EncodeToString(keyring.keys[0].mac(name, value))
+}
+
+func (keyring *Keyring) Verify(name string, signed string) ([]byte, bool) {
+	encoded, signature, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, false
+	}
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, false
+	}
+	for _, key := range keyring.keys {
+		if hmac.Equal(mac, key.mac(name, value)) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// Encrypt seals value with AES-256-GCM, using the cookie name as additional data
+func (keyring *Keyring) Encrypt(name string, value []byte) (string, error) {
+	aead := keyring.keys[0].aead
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, value, []byte(name))), nil
+}
+
+func (keyring *Keyring) Decrypt(name string, sealed string) ([]byte, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, false
+	}
+	for _, key := range keyring.keys {
+		nonceSize := key.aead.NonceSize()
+		if len(raw) < nonceSize {
+			return nil, false
+		}
+		if value, err := key.aead.Open(nil, raw[:nonceSize], raw[nonceSize:], []byte(name)); err == nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// SetCookieKeyring sets the keys used by the signed and encrypted cookie helpers
+func (jokerEngine *JokerEngine) SetCookieKeyring(keyring *Keyring) {
+	jokerEngine.cookieKeyring = keyring
+}
+
+type CookieOptions struct {
+	// Path defaults to "/"
+	Path   string
+	Domain string
+	// MaxAge is the cookie lifetime; zero makes a session cookie
+	MaxAge time.Duration
+	// Secure is forced on for TLS requests
+	Secure bool
+	// SameSite defaults to Lax
+	SameSite http.SameSite
+	// AllowScript lets JavaScript read the cookie; cookies are HttpOnly otherwise
+	AllowScript bool
+	Partitioned bool
+}
+
+// SetCookie adds a Set-Cookie header; unlike setHeaders it can be called for several cookies
+func (ctx *JokerContex) SetCookie(name, value string, options *CookieOptions) error {
+	if options == nil {
+		options = &CookieOptions{}
+	}
+	cookie := &http.Cookie{
+		Name:        name,
+		Value:       value,
+		Path:        options.Path,
+		Domain:      options.Domain,
+		Secure:      options.Secure || ctx.Scheme() == "https",
+		HttpOnly:    !options.AllowScript,
+		SameSite:    options.SameSite,
+		Partitioned: options.Partitioned,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == http.SameSiteDefaultMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	if cookie.SameSite == http.SameSiteNoneMode {
+		// Browsers reject SameSite=None without Secure
+		cookie.Secure = true
+	}
+	if options.MaxAge < 0 {
+		cookie.MaxAge = -1
+	} else if options.MaxAge > 0 {
+		cookie.MaxAge = int(options.MaxAge / time.Second)
+		cookie.Expires = time.Now().Add(options.MaxAge)
+	}
+	if err := cookie.Valid(); err != nil {
+		return err
+	}
+	header := cookie.String()
+	if len(header) > maxCookieSize {
+		return ErrCookieTooLarge
+	}
+	ctx.ResponseWriter.Header().Add("Set-Cookie", header)
+	return nil
+}
+
+// Cookie returns the value of the request cookie, or http.ErrNoCookie
+func (ctx *JokerContex) Cookie(name string) (string, error) {
+	cookie, err := ctx.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// DeleteCookie tells the client to drop the cookie; path and domain must match how it was set
+func (ctx *JokerContex) DeleteCookie(name string, options *CookieOptions) error {
+	deleted := CookieOptions{}
+	if options != nil {
+		deleted = *options
+	}
+	deleted.MaxAge = -1
+	return ctx.SetCookie(name, "", &deleted)
+}
+
+func (ctx *JokerContex) SetSignedCookie(name, value string, options *CookieOptions) error {
+	if ctx.engine.cookieKeyring == nil {
+		return ErrNoKeyring
+	}
+	return ctx.SetCookie(name, ctx.engine.cookieKeyring.Sign(name, []byte(value)), options)
+}
+
+// SignedCookie returns the cookie's value if its signature matches a key of the keyring
+func (ctx *JokerContex) SignedCookie(name string) (string, error) {
+	if ctx.engine.cookieKeyring == nil {
+		return "", ErrNoKeyring
+	}
+	raw, err := ctx.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	value, ok := ctx.engine.cookieKeyring.Verify(name, raw)
+	if !ok {
+		return "", ErrCookieInvalid
+	}
+	return string(value), nil
+}
+
+func (ctx *JokerContex) SetEncryptedCookie(name, value string, options *CookieOptions) error {
+	if ctx.engine.cookieKeyring == nil {
+		return ErrNoKeyring
+	}
+	sealed, err := ctx.engine.cookieKeyring.Encrypt(name, []byte(value))
+	if err != nil {
+		return err
+	}
+	return ctx.SetCookie(name, sealed, options)
+}
+
+// EncryptedCookie decrypts the cookie with any key of the keyring
+func (ctx *JokerContex) EncryptedCookie(name string) (string, error) {
+	if ctx.engine.cookieKeyring == nil {
+		return "", ErrNoKeyring
+	}
+	raw, err := ctx.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	value, ok := ctx.engine.cookieKeyring.Decrypt(name, raw)
+	if !ok {
+		return "", ErrCookieInvalid
+	}
+	return string(value), nil
+}
@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CORSOptions struct {
+	// AllowOrigins are exact origins, "*" for any, or wildcards such as "https://*.example.com"
+	AllowOrigins []string
+	// AllowOriginRegexps are matched against the whole origin
+	AllowOriginRegexps []string
+	// AllowOriginFunc is consulted when no other rule matches
+	AllowOriginFunc func(origin string) bool
+	// AllowMethods defaults to GET, HEAD, POST, PUT, PATCH and DELETE
+	AllowMethods []string
+	// AllowHeaders are the request headers allowed in preflights; empty or "*" allows what the browser asks for
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight, not sent when zero
+	MaxAge time.Duration
+}
+
+type corsPolicy struct {
+	options   CORSOptions
+	anyOrigin bool
+	exact     map[string]bool
+	wildcards [][2]string
+	regexps   []*regexp.Regexp
+	methods   string
+	method    map[string]bool
+	allowed   map[string]bool
+	headers   string
+	anyHeader bool
+	expose    string
+	maxAge    string
+}
+
+// CORS answers preflight requests and adds CORS headers to the routes it is used on.
+// Preflights are answered with 204 before any route handler, so no OPTIONS route is needed.
+func CORS(options CORSOptions) Middleware {
+	policy := newCORSPolicy(options)
+	return func(ctx *JokerContex) {
+		header := ctx.ResponseWriter.Header()
+		header.Add("Vary", "Origin")
+		origin := ctx.Request.Header.Get("Origin")
+		preflight := ctx.Request.Method == http.MethodOptions && ctx.Request.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if origin == "" || !policy.originAllowed(origin) {
+			if preflight {
+				ctx.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+			ctx.Next()
+			return
+		}
+		if !preflight {
+			policy.setOrigin(header, origin)
+			if policy.expose != "" {
+				header.Set("Access-Control-Expose-Headers", policy.expose)
+			}
+			ctx.Next()
+			return
+		}
+
+		method := strings.ToUpper(ctx.Request.Header.Get("Access-Control-Request-Method"))
+		requested := ctx.Request.Header.Get("Access-Control-Request-Headers")
+		if !policy.method[method] || !policy.headersAllowed(requested) {
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		policy.setOrigin(header, origin)
+		header.Set("Access-Control-Allow-Methods", policy.methods)
+		if policy.anyHeader {
+			if requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+		} else if policy.headers != "" {
+			header.Set("Access-Control-Allow-Headers", policy.headers)
+		}
+		if policy.maxAge != "" {
+			header.Set("Access-Control-Max-Age", policy.maxAge)
+		}
+		ctx.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func newCORSPolicy(options CORSOptions) *corsPolicy {
+	policy := &corsPolicy{options: options, exact: map[string]bool{}, method: map[string]bool{}, allowed: map[string]bool{}}
+	for _, origin := range options.AllowOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			policy.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			policy.wildcards = append(policy.wildcards, [2]string{prefix, suffix})
+		default:
+			policy.exact[origin] = true
+		}
+	}
+	for _, expr := range options.AllowOriginRegexps {
+		policy.regexps = append(policy.regexps, regexp.MustCompile("^(?:"+expr+")$"))
+	}
+	methods := options.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	methods = append([]string(nil), methods...)
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(method)
+		policy.method[methods[i]] = true
+	}
+	policy.methods = strings.Join(methods, ", ")
+	policy.anyHeader = len(options.AllowHeaders) == 0
+	for _, name := range options.AllowHeaders {
+		if name == "*" {
+			policy.anyHeader = true
+		}
+		policy.allowed[http.CanonicalHeaderKey(name)] = true
+	}
+	policy.headers = strings.Join(options.AllowHeaders, ", ")
+	policy.expose = strings.Join(options.ExposeHeaders, ", ")
+	if options.MaxAge > 0 {
+		policy.maxAge = strconv.Itoa(int(options.MaxAge.Seconds()))
+	}
+	return policy
+}
+
+func (policy *corsPolicy) originAllowed(origin string) bool {
+	lower := strings.ToLower(origin)
+	if policy.anyOrigin || policy.exact[lower] {
+		return true
+	}
+	for _, wildcard := range policy.wildcards {
+		if len(lower) > len(wildcard[0])+len(wildcard[1]) && strings.HasPrefix(lower, wildcard[0]) && strings.HasSuffix(lower, wildcard[1]) {
+			return true
+		}
+	}
+	for _, re := range policy.regexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return policy.options.AllowOriginFunc != nil && policy.options.AllowOriginFunc(origin)
+}
+
+func (policy *corsPolicy) headersAllowed(requested string) bool {
+	if policy.anyHeader {
+		return true
+	}
+	for _, name := range strings.Split(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !policy.allowed[http.CanonicalHeaderKey(name)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (policy *corsPolicy) setOrigin(header http.Header, origin string) {
+	// "*" cannot be combined with credentials, so the origin is echoed instead
+	if policy.anyOrigin && !policy.options.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if policy.options.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
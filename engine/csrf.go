@@ -0,0 +1,309 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type CSRFMode int
+
+const (
+	// CSRFDoubleSubmit keeps the token in a cookie, signed when the engine has a cookie keyring
+	CSRFDoubleSubmit CSRFMode = iota
+	// CSRFSynchronizer keeps the token in the session; the Sessions middleware must run first
+	CSRFSynchronizer
+)
+
+var (
+	errCSRFFailed  = NewHTTPError(http.StatusForbidden, "csrf_failed", "CSRF token missing or invalid")
+	errCSRFOrigin  = NewHTTPError(http.StatusForbidden, "csrf_origin", "cross-origin request rejected")
+	errCSRFSession = errors.New("CSRF synchronizer mode needs the Sessions middleware")
+)
+
+type CSRFOptions struct {
+	Mode CSRFMode
+	// CookieName defaults to "joker_csrf"
+	CookieName string
+	// Cookie sets the token cookie's attributes in double-submit mode
+	Cookie *CookieOptions
+	// HeaderName defaults to "X-CSRF-Token"
+	HeaderName string
+	// FieldName is the form field, "csrf_token" by default
+	FieldName string
+	// TrustedOrigins may post to us besides our own origin, e.g. "https://admin.example.com"
+	TrustedOrigins []string
+	// Exempt are paths that skip the check; a trailing "*" matches a prefix
+	Exempt []string
+	// ExemptFunc skips the check when it returns true
+	ExemptFunc func(ctx *JokerContex) bool
+}
+
+const (
+	csrfTokenSize     = 32
+	csrfContextKey    = "csrf"
+	csrfSessionKey    = "_csrf"
+	defaultCSRFHeader = "X-CSRF-Token"
+)
+
+type csrfState struct {
+	ctx     *JokerContex
+	options *CSRFOptions
+	token   []byte
+}
+
+// CSRF rejects unsafe requests without a valid token or from a foreign origin.
+// Pages get the token with ctx.CSRFToken() or the csrfToken and csrfField template funcs.
+func CSRF(options CSRFOptions) Middleware {
+	if options.CookieName == "" {
+		options.CookieName = "joker_csrf"
+	}
+	if options.HeaderName == "" {
+		options.HeaderName = defaultCSRFHeader
+	}
+	if options.FieldName == "" {
+		options.FieldName = "csrf_token"
+	}
+	return func(ctx *JokerContex) {
+		state := &csrfState{ctx: ctx, options: &options}
+		ctx.Set(csrfContextKey, state)
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			ctx.Next()
+			return
+		}
+		if options.exempt(ctx) {
+			ctx.Next()
+			return
+		}
+		if !options.originAllowed(ctx) {
+			ctx.AbortWithError(http.StatusForbidden, errCSRFOrigin)
+			return
+		}
+		expected, err := state.stored()
+		if err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		submitted := unmaskCSRFToken(options.submitted(ctx))
+		if expected == nil || submitted == nil || subtle.ConstantTimeCompare(expected, submitted) != 1 {
+			ctx.AbortWithError(http.StatusForbidden, errCSRFFailed)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (options *CSRFOptions) exempt(ctx *JokerContex) bool {
+	path := ctx.Request.URL.Path
+	for _, pattern := range options.Exempt {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(path, prefix) || pattern == path {
+			return true
+		}
+	}
+	return options.ExemptFunc != nil && options.ExemptFunc(ctx)
+}
+
+// originAllowed checks Origin, or Referer when a browser left Origin out
+func (options *CSRFOptions) originAllowed(ctx *JokerContex) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		referer, err := url.Parse(ctx.Request.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			// Neither header was sent, the token check still applies
+			return true
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+	if strings.EqualFold(origin, ctx.Scheme()+"://"+ctx.Host()) {
+		return true
+	}
+	for _, trusted := range options.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// submitted reads the token from the header or from a form field without consuming the body for the handler
+func (options *CSRFOptions) submitted(ctx *JokerContex) string {
+	if token := ctx.Request.Header.Get(options.HeaderName); token != "" {
+		return token
+	}
+	mediaType, params, _ := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		body, err := ctx.engine.requestBody(ctx.ResponseWriter, ctx.Request)
+		if err != nil {
+			return ""
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		// The body is already decoded, so the handler must not decode it again
+		ctx.Request.Header.Del("Content-Encoding")
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(data))
+		if err != nil {
+			return ""
+		}
+		values, _ := url.ParseQuery(string(data))
+		return values.Get(options.FieldName)
+	case "multipart/form-data":
+		if form := ctx.Request.MultipartForm; form != nil {
+			if values := form.Value[options.FieldName]; len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		return options.multipartField(ctx, params["boundary"])
+	}
+	return ""
+}
+
+// multipartField reads parts up to the token field and puts the bytes it read back in front of the
+// body, so MapUpload and MapPost still see the whole request. Parts before the token are kept in
+// memory up to the multipart memory size; a token placed after larger uploads is not found.
+func (options *CSRFOptions) multipartField(ctx *JokerContex, boundary string) string {
+	if boundary == "" {
+		return ""
+	}
+	body, err := ctx.engine.requestBody(ctx.ResponseWriter, ctx.Request)
+	if err != nil {
+		return ""
+	}
+	memory := ctx.engine.multipartMemory
+	if memory <= 0 {
+		memory = defaultMultipartMemory
+	}
+	var read bytes.Buffer
+	reader := multipart.NewReader(io.TeeReader(io.LimitReader(body, memory), &read), boundary)
+	defer func() {
+		// The body is already decoded, so the handler must not decode it again
+		ctx.Request.Header.Del("Content-Encoding")
+		ctx.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(&read, body), body}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return ""
+		}
+		if part.FormName() == options.FieldName && part.FileName() == "" {
+			token, _ := io.ReadAll(io.LimitReader(part, 1024))
+			return string(token)
+		}
+		if _, err := io.Copy(io.Discard, part); err != nil {
+			return ""
+		}
+	}
+}
+
+// stored returns the request's token, nil if it has none yet
+func (state *csrfState) stored() ([]byte, error) {
+	if state.token != nil {
+		return state.token, nil
+	}
+	ctx, options := state.ctx, state.options
+	var raw string
+	if options.Mode == CSRFSynchronizer {
+		session := ctx.Session()
+		if session == nil {
+			return nil, errCSRFSession
+		}
+		raw, _ = SessionValue[string](session, csrfSessionKey)
+	} else if ctx.engine.cookieKeyring != nil {
+		raw, _ = ctx.SignedCookie(options.CookieName)
+	} else {
+		raw, _ = ctx.Cookie(options.CookieName)
+	}
+	token, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil || len(token) != csrfTokenSize {
+		return nil, nil
+	}
+	state.token = token
+	return token, nil
+}
+
+// issue returns the request's token, creating and storing one when there is none
+func (state *csrfState) issue() ([]byte, error) {
+	token, err := state.stored()
+	if token != nil || err != nil {
+		return token, err
+	}
+	ctx, options := state.ctx, state.options
+	token = make([]byte, csrfTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(token)
+	switch {
+	case options.Mode == CSRFSynchronizer:
+		ctx.Session().Set(csrfSessionKey, raw)
+	case ctx.engine.cookieKeyring != nil:
+		err = ctx.SetSignedCookie(options.CookieName, raw, options.Cookie)
+	default:
+		err = ctx.SetCookie(options.CookieName, raw, options.Cookie)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state.token = token
+	return token, nil
+}
+
+// maskCSRFToken XORs the token with a fresh pad on every render, so compressed pages do not leak it (BREACH)
+func maskCSRFToken(token []byte) string {
+	masked := make([]byte, 2*len(token))
+	rand.Read(masked[:len(token)])
+	for i, b := range token {
+		masked[len(token)+i] = masked[i] ^ b
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func unmaskCSRFToken(masked string) []byte {
+	raw, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(raw) != 2*csrfTokenSize {
+		return nil
+	}
+	token := make([]byte, csrfTokenSize)
+	for i := range token {
+		token[i] = raw[i] ^ raw[csrfTokenSize+i]
+	}
+	return token
+}
+
+// CSRFToken returns a token to embed in forms or send in the X-CSRF-Token header; empty without the CSRF middleware
+func (ctx *JokerContex) CSRFToken() string {
+	state, ok := Value[*csrfState](ctx, csrfContextKey)
+	if !ok {
+		return ""
+	}
+	token, err := state.issue()
+	if err != nil {
+		ctx.Error(err)
+		return ""
+	}
+	return maskCSRFToken(token)
+}
+
+// csrfField renders the hidden form input carrying the token
+func (ctx *JokerContex) csrfField() template.HTML {
+	state, ok := Value[*csrfState](ctx, csrfContextKey)
+	token := ctx.CSRFToken()
+	if !ok || token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(state.options.FieldName) + `" value="` + token + `">`)
+}
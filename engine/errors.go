@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"errors"
+	"log"
+	"net/http"
+)
+
+// HTTPError is an error with the status and problem details sent to the client
+type HTTPError struct {
+	Status int
+	// Code is a machine-readable error code, e.g. "user_not_found"
+	Code string
+	// Message is shown to the client as the problem detail
+	Message string
+	// Details is any extra data for the client, e.g. validation errors
+	Details interface{}
+	// Type is a URI identifying the problem type, "about:blank" when empty
+	Type string
+	// Err is the underlying cause; it is logged but never sent to the client
+	Err error
+}
+
+func NewHTTPError(status int, code string, message string) *HTTPError {
+	return &HTTPError{Status: status, Code: code, Message: message}
+}
+
+func (e *HTTPError) Error() string {
+	msg := http.StatusText(e.Status)
+	if e.Message != "" {
+		msg = e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HTTPError) WithDetails(details interface{}) *HTTPError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+func (e *HTTPError) Wrap(err error) *HTTPError {
+	copied := *e
+	copied.Err = err
+	return &copied
+}
+
+var (
+	errMethodNotAllowed = NewHTTPError(http.StatusMethodNotAllowed, "method_not_allowed", "")
+	errNotFound         = NewHTTPError(http.StatusNotFound, "not_found", "")
+	errNotAcceptable    = NewHTTPError(http.StatusNotAcceptable, "not_acceptable", "no representation matches the Accept header")
+	errRequestTimeout   = NewHTTPError(http.StatusServiceUnavailable, "request_timeout", "the request timed out")
+)
+
+// ErrorHandler renders errors returned by handlers or raised by middleware
+type ErrorHandler func(ctx *JokerContex, err *HTTPError)
+
+// Problem is the RFC 9457 problem details document
+type Problem struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Code     string      `json:"code,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// DefaultErrorHandler writes application/problem+json; the cause of 5xx errors is only logged
+func DefaultErrorHandler(ctx *JokerContex, err *HTTPError) {
+	problem := Problem{
+		Type:     err.Type,
+		Title:    http.StatusText(err.Status),
+		Status:   err.Status,
+		Detail:   err.Message,
+		Instance: ctx.Request.URL.Path,
+		Code:     err.Code,
+		Details:  err.Details,
+	}
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if encodeErr := ctx.engine.encodeJSON(buf, ctx.Request, problem); encodeErr != nil {
+		ctx.ResponseWriter.WriteHeader(err.Status)
+		return
+	}
+	ctx.ResponseWriter.Header().Set("Content-Type", "application/problem+json")
+	ctx.engine.setServerHeader(ctx.ResponseWriter.Header())
+	ctx.ResponseWriter.WriteHeader(err.Status)
+	ctx.ResponseWriter.Write(buf.Bytes())
+}
+
+func (jokerEngine *JokerEngine) SetErrorHandler(handler ErrorHandler) {
+	jokerEngine.errorHandler = handler
+}
+
+// SetErrorHandler overrides the engine's error handler for routes of this group
+func (router *JokerRouter) SetErrorHandler(handler ErrorHandler) {
+	router.errorHandler = handler
+}
+
+// toHTTPError turns any error into an *HTTPError, using status when it is an error status
+func toHTTPError(status int, err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	if status < 400 {
+		status = http.StatusInternalServerError
+	}
+	return &HTTPError{Status: status, Err: err}
+}
+
+// HandleError renders err through the group's or the engine's error handler
+func (ctx *JokerContex) HandleError(status int, err error) {
+	httpErr := toHTTPError(status, err)
+	if httpErr.Status >= 500 || httpErr.Err != nil {
+		log.Println("[Error]:Handle in " + ctx.Request.URL.Path + " >>> " + httpErr.Error())
+	}
+	ctx.renderError(httpErr)
+}
+
+// renderError hands err to the group's, the engine's or the default error handler
+func (ctx *JokerContex) renderError(err *HTTPError) {
+	handler := ctx.errorHandler
+	if handler == nil {
+		handler = ctx.engine.errorHandler
+	}
+	if handler == nil {
+		handler = DefaultErrorHandler
+	}
+	handler(ctx, err)
+}
+
+// AbortWithError stops the chain and renders err through the error handler
+func (ctx *JokerContex) AbortWithError(status int, err error) {
+	ctx.Error(err)
+	ctx.HandleError(status, err)
+	ctx.abort(err)
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileResponse struct {
+	engine  *JokerEngine
+	path    string
+	name    string
+	reader  io.Reader
+	modTime time.Time
+}
+
+// Respond serves the content with Range and conditional request support when it can seek;
+// missing files are reported as a 404 through the error handler
+func (f fileResponse) Respond(w http.ResponseWriter, r *http.Request, status int) error {
+	reader, name, modTime := f.reader, f.name, f.modTime
+	if f.path != "" {
+		file, err := os.Open(f.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+				return errNotFound
+			}
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return errNotFound
+		}
+		reader, modTime = file, info.ModTime()
+		if name == "" {
+			name = filepath.Base(f.path)
+		}
+	}
+	if closer, ok := reader.(io.Closer); ok && f.path == "" {
+		defer closer.Close()
+	}
+	f.engine.setServerHeader(w.Header())
+	if seeker, ok := reader.(io.ReadSeeker); ok && status == http.StatusOK {
+		http.ServeContent(w, r, name, modTime, seeker)
+		return nil
+	}
+
+	// Without Seek there is no Range support, but If-Modified-Since still applies
+	if !modTime.IsZero() {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if w.Header().Get("Content-Type") == "" {
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.Copy(w, reader)
+	}
+	return nil
+}
+
+// File serves the file at path; handlers return its result directly
+func (ctx *JokerContex) File(path string) (int, interface{}) {
+	return http.StatusOK, fileResponse{engine: ctx.engine, path: path, name: ctx.attachmentName()}
+}
+
+// Stream serves content from reader; Range requests are supported when it implements io.Seeker.
+// The reader is closed afterwards if it implements io.Closer.
+func (ctx *JokerContex) Stream(reader io.Reader, modTime time.Time) (int, interface{}) {
+	return http.StatusOK, fileResponse{engine: ctx.engine, reader: reader, name: ctx.attachmentName(), modTime: modTime}
+}
+
+// Attachment makes the browser download the response as name
+func (ctx *JokerContex) Attachment(name string) *JokerContex {
+	ctx.ResponseWriter.Header().Set("Content-Disposition", contentDisposition("attachment", name))
+	return ctx
+}
+
+func (ctx *JokerContex) attachmentName() string {
+	_, params, err := mime.ParseMediaType(ctx.ResponseWriter.Header().Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+func contentDisposition(disposition, name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r > 0x7e || r < 0x20 || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	value := mime.FormatMediaType(disposition, map[string]string{"filename": ascii})
+	if ascii != name {
+		// RFC 6266 filename* for non-ASCII names
+		value += "; filename*=UTF-8''" + urlPathEscape(name)
+	}
+	return value
+}
+
+func urlPathEscape(s string) string {
+	const hex = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hex[c>>4])
+		sb.WriteByte(hex[c&15])
+	}
+	return sb.String()
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// JSONCodec lets the engine swap encoding/json for another implementation
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewEncoder(w io.Writer) JSONEncoder
+	NewDecoder(r io.Reader) JSONDecoder
+}
+
+// JSONEncoder is satisfied by *json.Encoder
+type JSONEncoder interface {
+	Encode(v interface{}) error
+	SetEscapeHTML(on bool)
+	SetIndent(prefix, indent string)
+}
+
+// JSONDecoder is satisfied by *json.Decoder
+type JSONDecoder interface {
+	Decode(v interface{}) error
+}
+
+type StdJSONCodec struct{}
+
+func (StdJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (StdJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (StdJSONCodec) NewEncoder(w io.Writer) JSONEncoder {
+	return json.NewEncoder(w)
+}
+
+func (StdJSONCodec) NewDecoder(r io.Reader) JSONDecoder {
+	return json.NewDecoder(r)
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	// Don't keep huge buffers alive in the pool
+	if buf.Cap() > 1<<20 {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+func (jokerEngine *JokerEngine) SetJSONCodec(codec JSONCodec) {
+	jokerEngine.jsonCodec = codec
+}
+
+func (jokerEngine *JokerEngine) SetJSONEscapeHTML(escape bool) {
+	jokerEngine.jsonNoEscapeHTML = !escape
+}
+
+// SetJSONPrettyQuery enables indented output when the query parameter is present, e.g. "pretty"
+func (jokerEngine *JokerEngine) SetJSONPrettyQuery(param string) {
+	jokerEngine.jsonPrettyQuery = param
+}
+
+func (jokerEngine *JokerEngine) JSONCodec() JSONCodec {
+	if jokerEngine.jsonCodec == nil {
+		return StdJSONCodec{}
+	}
+	return jokerEngine.jsonCodec
+}
+
+// encodeJSON writes v to w with the engine's codec and output options, without the trailing newline
+func (jokerEngine *JokerEngine) encodeJSON(w io.Writer, r *http.Request, v interface{}) error {
+	buf, pooled := w.(*bytes.Buffer)
+	if !pooled {
+		buf = getBuffer()
+		defer putBuffer(buf)
+	}
+	start := buf.Len()
+	encoder := jokerEngine.JSONCodec().NewEncoder(buf)
+	encoder.SetEscapeHTML(!jokerEngine.jsonNoEscapeHTML)
+	if jokerEngine.jsonPrettyQuery != "" && r != nil && r.URL.Query().Has(jokerEngine.jsonPrettyQuery) {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		buf.Truncate(start)
+		return err
+	}
+	if buf.Len() > start && buf.Bytes()[buf.Len()-1] == '\n' {
+		buf.Truncate(buf.Len() - 1)
+	}
+	if pooled {
+		return nil
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
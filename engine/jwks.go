@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	K         string `json:"k"`
+}
+
+// ParseJWKS reads a JSON Web Key Set; keys of unknown types are skipped
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []JWTKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, alg, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", k.KeyID, err)
+		}
+		if key == nil {
+			continue
+		}
+		if k.Algorithm != "" {
+			alg = k.Algorithm
+		}
+		keys = append(keys, JWTKey{ID: k.KeyID, Algorithm: alg, Key: key})
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (interface{}, string, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, "", err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, "", errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, "RS256", nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, "", nil
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, "ES256", nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, "", nil
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		return ed25519.PublicKey(x), "EdDSA", nil
+	case "oct":
+		secret, err := decode(k.K)
+		return secret, "", err
+	}
+	return nil, "", nil
+}
+
+// jwksSet caches a key set and reloads it when it is stale or a token names an unknown key.
+// Only one load runs at a time and it is not tied to any request, so a client that goes away
+// cannot cancel it and requests that already have their key do not wait for it.
+type jwksSet struct {
+	load     func(ctx context.Context) ([]byte, bool, error)
+	refresh  time.Duration
+	mu       sync.Mutex
+	keys     []JWTKey
+	loadErr  error
+	loadedAt time.Time
+	triedAt  time.Time
+	loading  chan struct{}
+}
+
+// minJWKSReload stops tokens with made-up key IDs, or an unreachable endpoint, from causing a reload on every request
+const minJWKSReload = time.Minute
+
+// jwksLoadTimeout bounds a load, which no longer has a request deadline
+const jwksLoadTimeout = 30 * time.Second
+
+func (set *jwksSet) Keys(ctx context.Context, kid string) ([]JWTKey, error) {
+	set.mu.Lock()
+	now := time.Now()
+	retry := now.Sub(set.triedAt) > minJWKSReload
+	stale := (set.keys == nil || now.Sub(set.loadedAt) > set.refresh) && retry
+	unknown := kid != "" && len(matchKeys(set.keys, kid)) == 0 && retry
+	if (stale || unknown) && set.loading == nil {
+		// Failed loads are throttled in reload; a successful first load does not hold back a rotation
+		if unknown && set.keys != nil {
+			set.triedAt = now
+		}
+		set.loading = make(chan struct{})
+		go set.reload(set.loading)
+	}
+	// Requests that can be answered from the current keys do not wait for a background refresh
+	loading := set.loading
+	if loading == nil || set.keys != nil && len(matchKeys(set.keys, kid)) > 0 {
+		defer set.mu.Unlock()
+		return set.result(kid)
+	}
+	set.mu.Unlock()
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.result(kid)
+}
+
+// result must be called with set.mu held
+func (set *jwksSet) result(kid string) ([]JWTKey, error) {
+	if set.keys == nil {
+		return nil, set.loadErr
+	}
+	return matchKeys(set.keys, kid), nil
+}
+
+func (set *jwksSet) reload(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksLoadTimeout)
+	defer cancel()
+	data, changed, err := set.load(ctx)
+	var keys []JWTKey
+	if err == nil && changed {
+		keys, err = ParseJWKS(data)
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	switch {
+	case err != nil:
+		// On a failed reload the previous keys stay in use, without keys the error is returned until the next try
+		set.loadErr, set.triedAt = err, time.Now()
+	case changed:
+		set.keys, set.loadErr, set.loadedAt = keys, nil, time.Now()
+	default:
+		set.loadErr, set.loadedAt = nil, time.Now()
+	}
+	set.loading = nil
+	close(done)
+}
+
+// JWKSFile loads keys from a JWKS file, re-reading it after refresh or when it changes
+func JWKSFile(path string, refresh time.Duration) JWTKeySet {
+	var modTime time.Time
+	return &jwksSet{
+		refresh: refresh,
+		load: func(context.Context) ([]byte, bool, error) {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, false, err
+			}
+			if info.ModTime().Equal(modTime) {
+				return nil, false, nil
+			}
+			data, err := os.ReadFile(path)
+			if err == nil {
+				modTime = info.ModTime()
+			}
+			return data, true, err
+		},
+	}
+}
+
+// JWKSURL fetches keys from a JWKS endpoint, refetching after refresh or when a token names an unknown key
+func JWKSURL(url string, refresh time.Duration) JWTKeySet {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return &jwksSet{
+		refresh: refresh,
+		load: func(ctx context.Context) ([]byte, bool, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, false, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, false, fmt.Errorf("jwks %s: %s", url, resp.Status)
+			}
+			data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+			return data, err == nil, err
+		},
+	}
+}
@@ -0,0 +1,422 @@
+package engine
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenMissing     = errors.New("token missing")
+	ErrTokenMalformed   = errors.New("token malformed")
+	ErrTokenAlgorithm   = errors.New("token algorithm not allowed")
+	ErrTokenKeyNotFound = errors.New("token signing key not found")
+	ErrTokenSignature   = errors.New("token signature invalid")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not valid yet")
+	ErrTokenIssuer      = errors.New("token issuer invalid")
+	ErrTokenAudience    = errors.New("token audience invalid")
+	// ErrKeysUnavailable wraps failures to load the key set, which are the server's fault rather than the token's
+	ErrKeysUnavailable = errors.New("signing keys unavailable")
+)
+
+// Claims is the decoded JWT payload; numbers are float64 as with encoding/json
+type Claims map[string]interface{}
+
+func (c Claims) Subject() string {
+	s, _ := c["sub"].(string)
+	return s
+}
+
+func (c Claims) Issuer() string {
+	s, _ := c["iss"].(string)
+	return s
+}
+
+// Audience returns "aud" whether it was sent as a string or an array
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var result []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	case []string:
+		return aud
+	}
+	return nil
+}
+
+// Time reads a NumericDate claim such as "exp"
+func (c Claims) Time(name string) (time.Time, bool) {
+	var seconds float64
+	switch v := c[name].(type) {
+	case float64:
+		seconds = v
+	case int64:
+		seconds = float64(v)
+	case int:
+		seconds = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		seconds = f
+	default:
+		return time.Time{}, false
+	}
+	// Values past year 9999, NaN or infinities are not times; the caller reports them as malformed
+	if math.IsNaN(seconds) || math.Abs(seconds) > maxClaimSeconds {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), true
+}
+
+// maxClaimSeconds is 9999-12-31T23:59:59Z
+const maxClaimSeconds = 253402300799
+
+// JWTKey is a key for one algorithm: HS256/384/512 use []byte, RS256 *rsa key, ES256 *ecdsa key
+// and EdDSA ed25519 key. Verification needs the public key, SignJWT the private one.
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	Key       interface{}
+}
+
+// JWTKeySet provides verification keys; kid is the token's key ID and may be empty
+type JWTKeySet interface {
+	Keys(ctx context.Context, kid string) ([]JWTKey, error)
+}
+
+type staticKeySet []JWTKey
+
+// StaticKeys is a fixed key set
+func StaticKeys(keys ...JWTKey) JWTKeySet {
+	return staticKeySet(keys)
+}
+
+func (keys staticKeySet) Keys(_ context.Context, kid string) ([]JWTKey, error) {
+	return matchKeys(keys, kid), nil
+}
+
+func matchKeys(keys []JWTKey, kid string) []JWTKey {
+	if kid == "" {
+		return keys
+	}
+	var matched []JWTKey
+	for _, key := range keys {
+		if key.ID == kid {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+type JWTOptions struct {
+	Keys JWTKeySet
+	// Algorithms limits accepted "alg" values, every supported one when empty
+	Algorithms []string
+	Issuer     string
+	Audience   string
+	// Leeway is the clock skew allowed when checking exp and nbf
+	Leeway time.Duration
+	// ContextKey is where the claims are stored with ctx.Set, "claims" when empty
+	ContextKey string
+	// Token extracts the token, the Authorization bearer token when nil
+	Token func(r *http.Request) string
+	// Optional lets requests without a token through; invalid tokens are still rejected
+	Optional bool
+}
+
+var errKeysUnavailable = NewHTTPError(http.StatusServiceUnavailable, "keys_unavailable", "")
+
+// JWT verifies the bearer token and stores its claims in the context,
+// read back with engine.Value[engine.Claims](ctx, "claims").
+// Key sets that cannot be loaded are answered with 503, their cause is only logged.
+func JWT(options JWTOptions) Middleware {
+	if options.Keys == nil {
+		panic("[Error]:JWT needs a key set")
+	}
+	if options.ContextKey == "" {
+		options.ContextKey = "claims"
+	}
+	if options.Token == nil {
+		options.Token = BearerToken
+	}
+	return func(ctx *JokerContex) {
+		token := options.Token(ctx.Request)
+		if token == "" && options.Optional {
+			ctx.Next()
+			return
+		}
+		claims, err := ParseJWT(ctx.Request.Context(), token, options)
+		if errors.Is(err, ErrKeysUnavailable) {
+			// The cause may name upstream URLs, so it is only logged
+			ctx.AbortWithError(http.StatusServiceUnavailable, errKeysUnavailable.Wrap(err))
+			return
+		}
+		if err != nil {
+			ctx.ResponseWriter.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			ctx.AbortWithError(http.StatusUnauthorized, NewHTTPError(http.StatusUnauthorized, "invalid_token", err.Error()))
+			return
+		}
+		ctx.Set(options.ContextKey, claims)
+		ctx.Next()
+	}
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// ParseJWT verifies the token's signature and registered claims and returns its claims
+func ParseJWT(ctx context.Context, token string, options JWTOptions) (Claims, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if !algorithmAllowed(header.Algorithm, options.Algorithms) {
+		return nil, ErrTokenAlgorithm
+	}
+	keys, err := options.Keys.Keys(ctx, header.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified, found := false, false
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		found = true
+		if verifyJWT(header.Algorithm, key.Key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrTokenKeyNotFound
+	}
+	if !verified {
+		return nil, ErrTokenSignature
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if err := validateClaims(claims, options, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func algorithmAllowed(alg string, allowed []string) bool {
+	if _, ok := jwtHashes[alg]; !ok {
+		return false
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"ES256": crypto.SHA256,
+	"EdDSA": 0,
+}
+
+func validateClaims(claims Claims, options JWTOptions, now time.Time) error {
+	if exp, ok := claims.Time("exp"); ok && now.After(exp.Add(options.Leeway)) {
+		return ErrTokenExpired
+	} else if !ok && claims["exp"] != nil {
+		return ErrTokenMalformed
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(options.Leeway).Before(nbf) {
+		return ErrTokenNotYetValid
+	} else if !ok && claims["nbf"] != nil {
+		return ErrTokenMalformed
+	}
+	if options.Issuer != "" && claims.Issuer() != options.Issuer {
+		return ErrTokenIssuer
+	}
+	if options.Audience != "" {
+		for _, aud := range claims.Audience() {
+			if aud == options.Audience {
+				return nil
+			}
+		}
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// verifyJWT checks the signature; the key type must match the algorithm so a public key is never used as an HMAC secret
+func verifyJWT(alg string, key interface{}, signed, signature []byte) bool {
+	hash := jwtHashes[alg]
+	switch alg {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			key = &k.PublicKey
+		}
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest(hash, signed), signature) == nil
+	case "ES256":
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			key = &k.PublicKey
+		}
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest(hash, signed), r, s)
+	case "EdDSA":
+		switch k := key.(type) {
+		case ed25519.PrivateKey:
+			key = k.Public()
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && len(pub) == ed25519.PublicKeySize && ed25519.Verify(pub, signed, signature)
+	}
+	return false
+}
+
+// SignJWT issues a token for claims, e.g. Claims{"sub": id, "exp": time.Now().Add(time.Hour).Unix()}
+func SignJWT(claims Claims, key JWTKey) (string, error) {
+	if _, ok := jwtHashes[key.Algorithm]; !ok {
+		return "", ErrTokenAlgorithm
+	}
+	header, err := json.Marshal(jwtHeader{Algorithm: key.Algorithm, KeyID: key.ID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := signJWT(key, []byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+var errSigningKey = errors.New("signing key does not match the algorithm")
+
+func signJWT(key JWTKey, signed []byte) ([]byte, error) {
+	hash := jwtHashes[key.Algorithm]
+	switch key.Algorithm {
+	case "HS256", "HS384", "HS512":
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return nil, errSigningKey
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil), nil
+	case "RS256":
+		priv, ok := key.Key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errSigningKey
+		}
+		return rsa.SignPKCS1v15(rand.Reader, priv, hash, digest(hash, signed))
+	case "ES256":
+		priv, ok := key.Key.(*ecdsa.PrivateKey)
+		if !ok || priv.Curve != elliptic.P256() {
+			return nil, errSigningKey
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest(hash, signed))
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case "EdDSA":
+		priv, ok := key.Key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errSigningKey
+		}
+		return ed25519.Sign(priv, signed), nil
+	}
+	return nil, ErrTokenAlgorithm
+}
@@ -1,10 +1,16 @@
 package engine
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"sync"
 )
 
+// ErrAborted is the abort reason when Abort is called without one
+var ErrAborted = errors.New("request aborted")
+
 type Middleware func(ctx *JokerContex)
 
 type JokerContex struct {
@@ -14,6 +20,16 @@ type JokerContex struct {
 	index            int
 	maxIndex         int
 	aborted          bool
+	abortReason      error
+	errors           []error
+	engine           *JokerEngine
+	writer           *responseWriter
+	errorHandler     ErrorHandler
+	cleanups         []func()
+	remote           *remoteInfo
+	untimed          context.Context
+	valuesMu         sync.RWMutex
+	values           map[string]interface{}
 }
 
 func (ctx *JokerContex) Next() {
@@ -23,30 +39,183 @@ func (ctx *JokerContex) Next() {
 	}
 }
 
+// Abort stops the chain; nothing after the current middleware runs, not even the route handler
 func (ctx *JokerContex) Abort() {
-	ctx.aborted = true
+	ctx.abort(ErrAborted)
+}
+
+func (ctx *JokerContex) abort(reason error) {
+	if !ctx.aborted {
+		ctx.aborted = true
+		ctx.abortReason = reason
+	}
+}
+
+func (ctx *JokerContex) IsAborted() bool {
+	return ctx.aborted
+}
+
+// AbortReason is why the chain stopped: the error given to AbortWithError, an *HTTPError
+// for AbortWithStatus and AbortWithStatusJSON, ErrAborted for Abort, or nil if it was not aborted
+func (ctx *JokerContex) AbortReason() error {
+	return ctx.abortReason
 }
 
 func (ctx *JokerContex) AbortWithStatus(statusCode int) {
 	ctx.ResponseWriter.WriteHeader(statusCode)
-	ctx.Abort()
+	ctx.abort(NewHTTPError(statusCode, "", ""))
 }
 
 func (ctx *JokerContex) AbortWithStatusJSON(statusCode int, jsonObj interface{}) {
-	jsonResult, err := json.Marshal(jsonObj)
-	if err != nil {
+	defer ctx.abort(NewHTTPError(statusCode, "", ""))
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := ctx.engine.encodeJSON(buf, ctx.Request, jsonObj); err != nil {
 		ctx.ResponseWriter.WriteHeader(http.StatusInternalServerError)
-		ctx.Abort()
 		return
 	}
 
 	ctx.ResponseWriter.Header().Set("Content-Type", "application/json")
 	ctx.ResponseWriter.WriteHeader(statusCode)
-	ctx.ResponseWriter.Write(jsonResult)
-	ctx.Abort()
+	ctx.ResponseWriter.Write(buf.Bytes())
 }
 
+// Error records err on the context for later middleware, e.g. a logger, and returns it
+func (ctx *JokerContex) Error(err error) error {
+	if err != nil {
+		ctx.errors = append(ctx.errors, err)
+	}
+	return err
+}
+
+// Errors returns the errors recorded with ctx.Error and AbortWithError, oldest first
+func (ctx *JokerContex) Errors() []error {
+	return ctx.errors
+}
+
+// run executes the chain and then runs the finish hooks, which also release resources such as upload temp files
+func (ctx *JokerContex) run() {
+	defer func() {
+		for i := len(ctx.cleanups) - 1; i >= 0; i-- {
+			ctx.cleanups[i]()
+		}
+	}()
+	ctx.Next()
+}
+
+// OnFinish registers hook to run once the chain completes, even after an abort or a panic; hooks run in reverse order
+func (ctx *JokerContex) OnFinish(hook func()) {
+	ctx.cleanups = append(ctx.cleanups, hook)
+}
+
+// Use adds middleware to this request's chain, to run before the route handler;
+// once the handler has started it is too late and the middleware is dropped
 func (ctx *JokerContex) Use(middleware Middleware) {
-	ctx.MiddlewareChains = append(ctx.MiddlewareChains, middleware)
+	last := len(ctx.MiddlewareChains) - 1
+	if ctx.index >= last {
+		log.Println("[Warning]:Use in " + ctx.Request.URL.Path + " after the handler ran is ignored")
+		return
+	}
+	// Never insert behind the current position, or the next Next would skip it
+	at := max(ctx.index+1, last)
+	ctx.MiddlewareChains = append(ctx.MiddlewareChains, nil)
+	copy(ctx.MiddlewareChains[at+1:], ctx.MiddlewareChains[at:])
+	ctx.MiddlewareChains[at] = middleware
 	ctx.maxIndex = len(ctx.MiddlewareChains)
 }
+
+// newContext builds the middleware chain for one request, ending with finalHandler
+func (jokerEngine *JokerEngine) newContext(w http.ResponseWriter, r *http.Request, finalHandler Middleware, chains ...[]Middleware) *JokerContex {
+	count := 1
+	for _, chain := range chains {
+		count += len(chain)
+	}
+	writer := newResponseWriter(w)
+	ctx := &JokerContex{
+		Request:          r,
+		ResponseWriter:   writer,
+		writer:           writer,
+		MiddlewareChains: make([]Middleware, 0, count),
+		index:            -1,
+		maxIndex:         count,
+		aborted:          false,
+		engine:           jokerEngine,
+	}
+	for _, chain := range chains {
+		ctx.MiddlewareChains = append(ctx.MiddlewareChains, chain...)
+	}
+	ctx.MiddlewareChains = append(ctx.MiddlewareChains, finalHandler)
+	// Let handlers that only see the request find their context and its values
+	var parent context.Context = requestContext{Context: r.Context(), ctx: ctx}
+	if jokerEngine.requestTimeout > 0 {
+		ctx.untimed = parent
+		var cancel context.CancelFunc
+		parent, cancel = context.WithTimeoutCause(parent, jokerEngine.requestTimeout, errEngineDeadline)
+		ctx.cleanups = append(ctx.cleanups, cancel)
+	}
+	ctx.Request = r.WithContext(parent)
+	return ctx
+}
+
+type jokerContextKey struct{}
+
+// requestContext resolves the JokerContex and the values set on it from the request's context.Context
+type requestContext struct {
+	context.Context
+	ctx *JokerContex
+}
+
+func (c requestContext) Value(key interface{}) interface{} {
+	switch k := key.(type) {
+	case jokerContextKey:
+		return c.ctx
+	case string:
+		if value, ok := c.ctx.Get(k); ok {
+			return value
+		}
+	}
+	return c.Context.Value(key)
+}
+
+// GetContext returns the JokerContex serving the request, or nil outside the engine
+func GetContext(request *http.Request) *JokerContex {
+	ctx, _ := request.Context().Value(jokerContextKey{}).(*JokerContex)
+	return ctx
+}
+
+// Set stores a value for the rest of the request; it is also visible through request.Context().Value(key)
+func (ctx *JokerContex) Set(key string, value interface{}) {
+	ctx.valuesMu.Lock()
+	defer ctx.valuesMu.Unlock()
+	if ctx.values == nil {
+		ctx.values = make(map[string]interface{})
+	}
+	ctx.values[key] = value
+}
+
+func (ctx *JokerContex) Get(key string) (interface{}, bool) {
+	ctx.valuesMu.RLock()
+	defer ctx.valuesMu.RUnlock()
+	value, ok := ctx.values[key]
+	return value, ok
+}
+
+// Value returns the value stored under key if it has type T
+func Value[T any](ctx *JokerContex, key string) (T, bool) {
+	var zero T
+	if ctx == nil {
+		return zero, false
+	}
+	raw, ok := ctx.Get(key)
+	if !ok {
+		return zero, false
+	}
+	value, ok := raw.(T)
+	return value, ok
+}
+
+// RequestValue is Value for code that only has the *http.Request
+func RequestValue[T any](request *http.Request, key string) (T, bool) {
+	value, ok := request.Context().Value(key).(T)
+	return value, ok
+}
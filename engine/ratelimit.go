@@ -0,0 +1,258 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket refills Limit tokens per Window up to Burst, allowing short bursts
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow weighs the previous window's count to smooth the window edges
+	SlidingWindow
+	// FixedWindow counts requests per aligned Window
+	FixedWindow
+)
+
+var errRateLimited = NewHTTPError(http.StatusTooManyRequests, "rate_limited", "too many requests")
+
+// RateLimitState is what a store keeps per key; its meaning depends on the algorithm
+type RateLimitState struct {
+	Count    float64
+	Previous float64
+	Start    time.Time
+}
+
+// RateLimitStore keeps limiter state; Update must apply update atomically for the key
+type RateLimitStore interface {
+	Update(key string, ttl time.Duration, update func(state *RateLimitState))
+}
+
+type RateLimitOptions struct {
+	Algorithm RateLimitAlgorithm
+	// Limit requests are allowed per Window
+	Limit  int
+	Window time.Duration
+	// Burst is the token bucket capacity, Limit when zero
+	Burst int
+	// Key picks who is limited, RateLimitByIP when nil; requests with an empty key are not limited
+	Key func(ctx *JokerContex) string
+	// Store defaults to a new in-memory store
+	Store RateLimitStore
+	// Prefix namespaces keys in a shared store, unique per limiter when empty
+	Prefix string
+}
+
+var rateLimiterCount atomic.Uint64
+
+// RateLimit limits the routes it is used on and answers 429 through the error handler.
+// Use it on a group to give that group its own limit.
+func RateLimit(options RateLimitOptions) Middleware {
+	if options.Limit <= 0 || options.Window <= 0 {
+		panic("[Error]:RateLimit needs a positive Limit and Window")
+	}
+	if options.Key == nil {
+		options.Key = RateLimitByIP
+	}
+	if options.Store == nil {
+		options.Store = NewMemoryRateLimitStore()
+	}
+	if options.Prefix == "" {
+		options.Prefix = "ratelimit:" + strconv.FormatUint(rateLimiterCount.Add(1), 10) + ":"
+	}
+	if options.Burst <= 0 {
+		options.Burst = options.Limit
+	}
+	// State must outlive a full refill, or an idle token bucket would come back full too early
+	ttl := 2 * options.Window
+	if refill := time.Duration(int64(options.Window) * int64(options.Burst) / int64(options.Limit)); refill > ttl {
+		ttl = refill
+	}
+	policy := strconv.Itoa(options.Limit) + ";w=" + strconv.Itoa(int(math.Ceil(options.Window.Seconds())))
+	return func(ctx *JokerContex) {
+		key := options.Key(ctx)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		var result rateLimitResult
+		options.Store.Update(options.Prefix+key, ttl, func(state *RateLimitState) {
+			result = options.take(state, time.Now())
+		})
+		header := ctx.ResponseWriter.Header()
+		header.Set("RateLimit-Policy", policy)
+		header.Set("RateLimit-Limit", strconv.Itoa(result.limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		header.Set("RateLimit-Reset", seconds(result.reset))
+		if !result.allowed {
+			header.Set("Retry-After", seconds(result.retryAfter))
+			ctx.AbortWithError(http.StatusTooManyRequests, errRateLimited)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func (options RateLimitOptions) take(state *RateLimitState, now time.Time) rateLimitResult {
+	limit := float64(options.Limit)
+	window := options.Window
+	switch options.Algorithm {
+	case FixedWindow:
+		start := now.Truncate(window)
+		if !state.Start.Equal(start) {
+			state.Start, state.Count = start, 0
+		}
+		result := rateLimitResult{limit: options.Limit, reset: start.Add(window).Sub(now)}
+		if state.Count < limit {
+			state.Count++
+			result.allowed = true
+		}
+		result.remaining = int(limit - state.Count)
+		result.retryAfter = result.reset
+		return result
+	case SlidingWindow:
+		start := now.Truncate(window)
+		if !state.Start.Equal(start) {
+			if state.Start.Equal(start.Add(-window)) {
+				state.Previous = state.Count
+			} else {
+				state.Previous = 0
+			}
+			state.Start, state.Count = start, 0
+		}
+		elapsed := now.Sub(start)
+		weight := 1 - float64(elapsed)/float64(window)
+		estimate := state.Previous*weight + state.Count
+		result := rateLimitResult{limit: options.Limit, reset: window - elapsed}
+		if estimate+1 <= limit {
+			state.Count++
+			estimate++
+			result.allowed = true
+		}
+		result.remaining = int(math.Max(0, math.Floor(limit-estimate)))
+		result.retryAfter = window - elapsed
+		if state.Count+1 <= limit && state.Previous > 0 {
+			// Wait until enough of the previous window has slid out
+			needed := 1 - (limit-state.Count-1)/state.Previous
+			result.retryAfter = time.Duration(needed*float64(window)) - elapsed
+		}
+		return result
+	default:
+		capacity := float64(options.Burst)
+		rate := limit / float64(window)
+		if state.Start.IsZero() {
+			state.Count = capacity
+		} else {
+			state.Count = math.Min(capacity, state.Count+float64(now.Sub(state.Start))*rate)
+		}
+		state.Start = now
+		result := rateLimitResult{limit: options.Burst}
+		if state.Count >= 1 {
+			state.Count--
+			result.allowed = true
+		}
+		result.remaining = int(state.Count)
+		result.reset = time.Duration((capacity - state.Count) / rate)
+		result.retryAfter = time.Duration((1 - state.Count) / rate)
+		return result
+	}
+}
+
+// RateLimitByIP keys requests by the client's IP address, see SetTrustedProxies
+func RateLimitByIP(ctx *JokerContex) string {
+	return ctx.ClientIP()
+}
+
+// RateLimitByHeader keys requests by a header such as an API key
+func RateLimitByHeader(name string) func(ctx *JokerContex) string {
+	return func(ctx *JokerContex) string {
+		return ctx.Request.Header.Get(name)
+	}
+}
+
+// RateLimitByValue keys requests by a context value, e.g. the user set by auth middleware
+func RateLimitByValue(key string) func(ctx *JokerContex) string {
+	return func(ctx *JokerContex) string {
+		value, ok := ctx.Get(key)
+		if !ok || value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+type memoryRateLimitEntry struct {
+	state     RateLimitState
+	expiresAt time.Time
+}
+
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryRateLimitEntry
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{entries: map[string]*memoryRateLimitEntry{}, lastSweep: time.Now()}
+}
+
+func (store *memoryRateLimitStore) Update(key string, ttl time.Duration, update func(state *RateLimitState)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now()
+	if now.Sub(store.lastSweep) > time.Minute {
+		for k, entry := range store.entries {
+			if now.After(entry.expiresAt) {
+				delete(store.entries, k)
+			}
+		}
+		store.lastSweep = now
+	}
+	entry, ok := store.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &memoryRateLimitEntry{}
+		store.entries[key] = entry
+	}
+	update(&entry.state)
+	entry.expiresAt = now.Add(ttl)
+}
+
+type cacheRateLimitStore struct {
+	mu    sync.Mutex
+	cache *jokerCache
+}
+
+// NewCacheRateLimitStore keeps limiter state in the engine's Cache, e.g. NewCacheRateLimitStore(joker.Cache)
+func NewCacheRateLimitStore(cache *jokerCache) RateLimitStore {
+	return &cacheRateLimitStore{cache: cache}
+}
+
+func (store *cacheRateLimitStore) Update(key string, ttl time.Duration, update func(state *RateLimitState)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var state RateLimitState
+	if value, ok := store.cache.TryGet(key); ok {
+		state, _ = value.(RateLimitState)
+	}
+	update(&state)
+	store.cache.Set(key, state, time.Now().Add(ttl+time.Second).Unix())
+}
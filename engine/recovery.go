@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicReporter receives recovered panics, e.g. to forward them to an error tracker
+type PanicReporter func(ctx *JokerContex, recovered interface{}, stack []byte)
+
+// Recovery turns panics in later middleware and handlers into a 500 through the error handler
+func Recovery() Middleware {
+	return RecoveryWithReporter(nil)
+}
+
+// RecoveryWithReporter is Recovery that also hands every panic to reporter
+func RecoveryWithReporter(reporter PanicReporter) Middleware {
+	return func(ctx *JokerContex) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				// Deliberate abort, let net/http drop the connection quietly
+				panic(recovered)
+			}
+			stack := debug.Stack()
+			log.Printf("[Panic]:%s %s from %s >>> %v\n%s", ctx.Request.Method, ctx.Request.URL.RequestURI(), ctx.ClientIP(), recovered, stack)
+			if reporter != nil {
+				reporter(ctx, recovered, stack)
+			}
+			err := ctx.Error(fmt.Errorf("panic: %v", recovered))
+			ctx.abort(err)
+			if ctx.Written() {
+				// Part of the response is out, dropping the connection is all that is left
+				panic(http.ErrAbortHandler)
+			}
+			ctx.renderError(NewHTTPError(http.StatusInternalServerError, "internal_error", "").Wrap(err))
+		}()
+		ctx.Next()
+	}
+}
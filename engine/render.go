@@ -0,0 +1,415 @@
+package engine
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrNotRenderable is returned by a Renderer that cannot encode the given value,
+// so that content negotiation can fall back to the next acceptable renderer.
+var ErrNotRenderable = errors.New("value cannot be rendered by this renderer")
+
+// maxRenderDepth stops the XML, YAML and MessagePack writers on cyclic values,
+// which would otherwise overflow the stack beyond what Recovery can catch
+const maxRenderDepth = 1000
+
+var errRenderDepth = errors.New("value nested too deeply, it may be cyclic")
+
+// Renderer encodes a handler result; the request is passed for per-request options such as ?pretty
+type Renderer interface {
+	Render(w io.Writer, r *http.Request, response interface{}) error
+}
+
+type RendererFunc func(w io.Writer, r *http.Request, response interface{}) error
+
+func (f RendererFunc) Render(w io.Writer, r *http.Request, response interface{}) error {
+	return f(w, r, response)
+}
+
+// Responder is a handler result that writes itself instead of going through content negotiation
+type Responder interface {
+	Respond(w http.ResponseWriter, r *http.Request, status int) error
+}
+
+type rendererEntry struct {
+	mediaType string
+	typ       string
+	subtype   string
+	renderer  Renderer
+}
+
+// RegisterRenderer adds a renderer for the media type, replacing any renderer
+// already registered for it. Renderers registered first win on ties.
+func (jokerEngine *JokerEngine) RegisterRenderer(mediaType string, renderer Renderer) {
+	typ, subtype := splitMediaType(mediaType)
+	entry := rendererEntry{
+		mediaType: mediaType,
+		typ:       typ,
+		subtype:   subtype,
+		renderer:  renderer,
+	}
+	for i, registered := range jokerEngine.renderers {
+		if registered.typ == typ && registered.subtype == subtype {
+			jokerEngine.renderers[i] = entry
+			return
+		}
+	}
+	jokerEngine.renderers = append(jokerEngine.renderers, entry)
+}
+
+func (jokerEngine *JokerEngine) initRenderers() {
+	defaults := []struct {
+		mediaType string
+		renderer  Renderer
+	}{
+		{"application/json", RendererFunc(jokerEngine.encodeJSON)},
+		{"application/xml", RendererFunc(renderXML)},
+		{"text/xml", RendererFunc(renderXML)},
+		{"application/yaml", RendererFunc(renderYAML)},
+		{"application/x-yaml", RendererFunc(renderYAML)},
+		{"text/yaml", RendererFunc(renderYAML)},
+		{"text/csv", RendererFunc(renderCSV)},
+		{"application/msgpack", RendererFunc(renderMsgPack)},
+		{"application/x-msgpack", RendererFunc(renderMsgPack)},
+	}
+	registered := jokerEngine.renderers
+	jokerEngine.renderers = nil
+	for _, d := range defaults {
+		jokerEngine.RegisterRenderer(d.mediaType, d.renderer)
+	}
+	// User renderers registered before Init override the defaults
+	for _, entry := range registered {
+		jokerEngine.RegisterRenderer(entry.mediaType, entry.renderer)
+	}
+}
+
+// writeResponse renders a handler result according to the request's Accept header
+func (jokerEngine *JokerEngine) writeResponse(ctx *JokerContex, status int, response interface{}) {
+	w, r := ctx.ResponseWriter, ctx.Request
+	switch r.Context().Err() {
+	case context.DeadlineExceeded:
+		ctx.HandleError(http.StatusServiceUnavailable, errRequestTimeout)
+		return
+	case context.Canceled:
+		// The client is gone, nobody will read the response
+		return
+	}
+	if err, ok := response.(error); ok {
+		ctx.HandleError(status, err)
+		return
+	}
+	if response == nil {
+		w.WriteHeader(status)
+		return
+	}
+	if responder, ok := response.(Responder); ok {
+		if err := responder.Respond(w, r, status); err != nil {
+			ctx.HandleError(http.StatusInternalServerError, err)
+		}
+		return
+	}
+	candidates := negotiate(r.Header.Get("Accept"), jokerEngine.renderers)
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for _, entry := range candidates {
+		buf.Reset()
+		err := entry.renderer.Render(buf, r, response)
+		if errors.Is(err, ErrNotRenderable) {
+			continue
+		}
+		if err != nil {
+			ctx.HandleError(http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", entry.mediaType)
+		ctx.engine.setServerHeader(w.Header())
+		w.WriteHeader(status)
+		w.Write(buf.Bytes())
+		return
+	}
+	ctx.HandleError(http.StatusNotAcceptable, errNotAcceptable)
+}
+
+type acceptRange struct {
+	typ     string
+	subtype string
+	q       float64
+	order   int
+}
+
+func splitMediaType(mediaType string) (string, string) {
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	typ, subtype, found := strings.Cut(mediaType, "/")
+	if !found {
+		return typ, "*"
+	}
+	return typ, subtype
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for i, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		typ, subtype := splitMediaType(part)
+		q := 1.0
+		for _, param := range strings.Split(part, ";")[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{typ: typ, subtype: subtype, q: q, order: i})
+	}
+	return ranges
+}
+
+// negotiate returns the acceptable renderers ordered by preference. JSON reached through a
+// wildcard wins ties, and browsers (which accept text/html) get it ahead of everything else,
+// so "text/html,...,application/xml;q=0.9,*/*;q=0.8" keeps getting JSON while
+// "application/xml, */*;q=0.1" gets XML.
+func negotiate(accept string, renderers []rendererEntry) []rendererEntry {
+	if strings.TrimSpace(accept) == "" {
+		return renderers
+	}
+	ranges := parseAccept(accept)
+	browser := false
+	for _, ar := range ranges {
+		if ar.typ == "text" && ar.subtype == "html" && ar.q > 0 {
+			browser = true
+		}
+	}
+	type candidate struct {
+		entry    rendererEntry
+		q        float64
+		order    int
+		index    int
+		wildcard bool
+	}
+	var candidates []candidate
+	for i, entry := range renderers {
+		matched, specificity := acceptRange{}, 0
+		for _, ar := range ranges {
+			s := 0
+			switch {
+			case ar.typ == entry.typ && ar.subtype == entry.subtype:
+				s = 3
+			case ar.typ == entry.typ && ar.subtype == "*":
+				s = 2
+			case ar.typ == "*" && ar.subtype == "*":
+				s = 1
+			}
+			if s > specificity {
+				matched, specificity = ar, s
+			}
+		}
+		if specificity == 0 || matched.q <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{entry: entry, q: matched.q, order: matched.order, index: i, wildcard: specificity < 3})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iJSON := candidates[i].wildcard && candidates[i].entry.typ == "application" && candidates[i].entry.subtype == "json"
+		jJSON := candidates[j].wildcard && candidates[j].entry.typ == "application" && candidates[j].entry.subtype == "json"
+		if iJSON != jJSON && browser {
+			return iJSON
+		}
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		if iJSON != jJSON {
+			return iJSON
+		}
+		if candidates[i].order != candidates[j].order {
+			return candidates[i].order < candidates[j].order
+		}
+		return candidates[i].index < candidates[j].index
+	})
+	result := make([]rendererEntry, len(candidates))
+	for i, c := range candidates {
+		result[i] = c.entry
+	}
+	return result
+}
+
+func renderXML(w io.Writer, _ *http.Request, response interface{}) error {
+	io.WriteString(w, xml.Header)
+	v := reflect.ValueOf(response)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Map:
+		return writeXMLValue(w, "response", v, 0)
+	case v.Kind() == reflect.Struct && v.Type().Name() == "":
+		return writeXMLValue(w, "response", v, 0)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		return writeXMLValue(w, "response", v, 0)
+	}
+	return xml.NewEncoder(w).Encode(response)
+}
+
+// writeXMLValue covers the shapes encoding/xml refuses: maps, anonymous structs and bare slices
+func writeXMLValue(w io.Writer, name string, v reflect.Value, depth int) error {
+	if depth > maxRenderDepth {
+		return errRenderDepth
+	}
+	if !validXMLName(name) {
+		return ErrNotRenderable
+	}
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		fmt.Fprintf(w, "<%s>", name)
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			if err := writeXMLValue(w, fmt.Sprint(key.Interface()), v.MapIndex(key), depth+1); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "</%s>", name)
+		return nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		fmt.Fprintf(w, "<%s>", name)
+		for i := 0; i < v.Len(); i++ {
+			if err := writeXMLValue(w, "item", v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "</%s>", name)
+		return nil
+	case reflect.Struct:
+		if v.Type().Name() != "" {
+			break
+		}
+		fmt.Fprintf(w, "<%s>", name)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldName := tagName(field, "xml")
+			if fieldName == "-" {
+				continue
+			}
+			if err := writeXMLValue(w, fieldName, v.Field(i), depth+1); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "</%s>", name)
+		return nil
+	}
+	if !v.IsValid() {
+		fmt.Fprintf(w, "<%s></%s>", name, name)
+		return nil
+	}
+	return xml.NewEncoder(w).EncodeElement(v.Interface(), xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+// validXMLName accepts names that cannot break out of the element, e.g. not "1st key" or "a><b"
+func validXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || unicode.IsDigit(r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// tagName returns the field name from the given struct tag, falling back to the json tag
+func tagName(field reflect.StructField, key string) string {
+	for _, k := range []string{key, "json"} {
+		if tag, ok := field.Tag.Lookup(k); ok {
+			name, _, _ := strings.Cut(tag, ",")
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return field.Name
+}
+
+func renderCSV(w io.Writer, _ *http.Request, response interface{}) error {
+	v := reflect.ValueOf(response)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return ErrNotRenderable
+	}
+	elemType := v.Type().Elem()
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return ErrNotRenderable
+	}
+	var fields []int
+	var header []string
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := tagName(field, "csv")
+		if name == "-" {
+			continue
+		}
+		fields = append(fields, i)
+		header = append(header, name)
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(fields))
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		for elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		for j, index := range fields {
+			if !elem.IsValid() {
+				record[j] = ""
+				continue
+			}
+			record[j] = fmt.Sprint(elem.Field(index).Interface())
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
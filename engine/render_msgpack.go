@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// renderMsgPack encodes the response as MessagePack; structs become maps keyed like JSON
+func renderMsgPack(w io.Writer, _ *http.Request, response interface{}) error {
+	m := &msgpackWriter{}
+	if err := m.value(reflect.ValueOf(response)); err != nil {
+		return err
+	}
+	_, err := w.Write(m.buf)
+	return err
+}
+
+type msgpackWriter struct {
+	buf   []byte
+	depth int
+}
+
+func (m *msgpackWriter) value(v reflect.Value) error {
+	if m.depth > maxRenderDepth {
+		return errRenderDepth
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+		m.buf = append(m.buf, 0xc0)
+		return nil
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		m.str(t.Format(time.RFC3339Nano))
+		return nil
+	}
+	if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := tm.MarshalText()
+		if err != nil {
+			return err
+		}
+		m.str(string(text))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			m.buf = append(m.buf, 0xc3)
+		} else {
+			m.buf = append(m.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		m.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		m.uint(v.Uint())
+	case reflect.Float32:
+		m.buf = append(m.buf, 0xca)
+		m.buf = binary.BigEndian.AppendUint32(m.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		m.buf = append(m.buf, 0xcb)
+		m.buf = binary.BigEndian.AppendUint64(m.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		m.str(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			m.bin(data)
+			return nil
+		}
+		m.header(v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := m.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		m.header(len(keys), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			if err := m.value(key); err != nil {
+				return err
+			}
+			if err := m.value(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var fields []int
+		var names []string
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := tagName(field, "msgpack")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			fields = append(fields, i)
+			names = append(names, name)
+		}
+		m.header(len(fields), 0x80, 0xde, 0xdf)
+		for i, index := range fields {
+			m.str(names[i])
+			if err := m.value(v.Field(index)); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrNotRenderable
+	}
+	return nil
+}
+
+// header writes a fixarray/fixmap prefix or its 16/32-bit form
+func (m *msgpackWriter) header(n int, fix, code16, code32 byte) {
+	switch {
+	case n < 16:
+		m.buf = append(m.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, code16)
+		m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, code32)
+		m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(n))
+	}
+}
+
+func (m *msgpackWriter) int(i int64) {
+	switch {
+	case i >= 0:
+		m.uint(uint64(i))
+	case i >= -32:
+		m.buf = append(m.buf, byte(i))
+	case i >= math.MinInt8:
+		m.buf = append(m.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		m.buf = append(m.buf, 0xd1)
+		m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(i))
+	case i >= math.MinInt32:
+		m.buf = append(m.buf, 0xd2)
+		m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(i))
+	default:
+		m.buf = append(m.buf, 0xd3)
+		m.buf = binary.BigEndian.AppendUint64(m.buf, uint64(i))
+	}
+}
+
+func (m *msgpackWriter) uint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		m.buf = append(m.buf, byte(u))
+	case u <= math.MaxUint8:
+		m.buf = append(m.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		m.buf = append(m.buf, 0xcd)
+		m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(u))
+	case u <= math.MaxUint32:
+		m.buf = append(m.buf, 0xce)
+		m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(u))
+	default:
+		m.buf = append(m.buf, 0xcf)
+		m.buf = binary.BigEndian.AppendUint64(m.buf, u)
+	}
+}
+
+func (m *msgpackWriter) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		m.buf = append(m.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xda)
+		m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xdb)
+		m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(n))
+	}
+	m.buf = append(m.buf, s...)
+}
+
+func (m *msgpackWriter) bin(data []byte) {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		m.buf = append(m.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		m.buf = append(m.buf, 0xc5)
+		m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(n))
+	default:
+		m.buf = append(m.buf, 0xc6)
+		m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(n))
+	}
+	m.buf = append(m.buf, data...)
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// renderYAML writes a block-style YAML document using the same field naming as JSON
+func renderYAML(w io.Writer, _ *http.Request, response interface{}) error {
+	y := &yamlWriter{w: w}
+	y.value(reflect.ValueOf(response), 0, false)
+	return y.err
+}
+
+type yamlWriter struct {
+	w     io.Writer
+	err   error
+	depth int
+}
+
+func (y *yamlWriter) write(s string) {
+	if y.err == nil {
+		_, y.err = io.WriteString(y.w, s)
+	}
+}
+
+// value writes v; inline is true when v follows a "key:" or "- " on the same line
+func (y *yamlWriter) value(v reflect.Value, indent int, inline bool) {
+	if y.err != nil {
+		return
+	}
+	if y.depth > maxRenderDepth {
+		y.err = errRenderDepth
+		return
+	}
+	y.depth++
+	defer func() { y.depth-- }()
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+		y.scalar("null", inline)
+		return
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			y.err = err
+			return
+		}
+		y.scalar(yamlString(string(text)), inline)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Len() == 0 {
+			y.scalar("{}", inline)
+			return
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		if inline {
+			y.write("\n")
+		}
+		for _, key := range keys {
+			y.entry(yamlString(fmt.Sprint(key.Interface())), v.MapIndex(key), indent)
+		}
+	case reflect.Struct:
+		fields := 0
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := tagName(field, "yaml")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if fields == 0 && inline {
+				y.write("\n")
+			}
+			fields++
+			y.entry(yamlString(name), v.Field(i), indent)
+		}
+		if fields == 0 {
+			y.scalar("{}", inline)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			y.scalar(yamlString(string(v.Bytes())), inline)
+			return
+		}
+		if v.Len() == 0 {
+			y.scalar("[]", inline)
+			return
+		}
+		if inline {
+			y.write("\n")
+		}
+		for i := 0; i < v.Len(); i++ {
+			y.write(strings.Repeat("  ", indent) + "-")
+			y.child(v.Index(i), indent+1)
+		}
+	case reflect.String:
+		y.scalar(yamlString(v.String()), inline)
+	case reflect.Bool:
+		y.scalar(strconv.FormatBool(v.Bool()), inline)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		y.scalar(strconv.FormatInt(v.Int(), 10), inline)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		y.scalar(strconv.FormatUint(v.Uint(), 10), inline)
+	case reflect.Float32, reflect.Float64:
+		y.scalar(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), inline)
+	default:
+		y.err = ErrNotRenderable
+	}
+}
+
+func (y *yamlWriter) entry(key string, v reflect.Value, indent int) {
+	y.write(strings.Repeat("  ", indent) + key + ":")
+	y.child(v, indent+1)
+}
+
+// child writes a nested value after "key:" or "-"
+func (y *yamlWriter) child(v reflect.Value, indent int) {
+	y.value(v, indent, true)
+}
+
+func (y *yamlWriter) scalar(s string, inline bool) {
+	if inline {
+		y.write(" ")
+	}
+	y.write(s + "\n")
+}
+
+func yamlString(s string) string {
+	if s == "" {
+		return `""`
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`\n\t\\") || strings.TrimSpace(s) != s || strings.HasPrefix(s, "-") || strings.HasPrefix(s, "?") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -1,17 +1,18 @@
 package engine
 
 import (
-	"encoding/json"
-	"log"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 )
 
 type JokerRouter struct {
-	prefix      string
-	engine      *JokerEngine
-	middlewares []Middleware
+	prefix       string
+	engine       *JokerEngine
+	middlewares  []Middleware
+	errorHandler ErrorHandler
+	requirements []requirement
 }
 
 func (engine *JokerEngine) NewRouter() *JokerRouter {
@@ -27,208 +28,186 @@ func (router *JokerRouter) Group(prefix string) *JokerRouter {
 	}
 	if router.prefix == "/" {
 		return &JokerRouter{
-			prefix:      prefix,
-			engine:      router.engine,
-			middlewares: router.middlewares,
+			prefix:       prefix,
+			engine:       router.engine,
+			middlewares:  append([]Middleware(nil), router.middlewares...),
+			errorHandler: router.errorHandler,
+			requirements: append([]requirement(nil), router.requirements...),
 		}
 	} else {
 		return &JokerRouter{
-			prefix:      router.prefix + prefix,
-			engine:      router.engine,
-			middlewares: router.middlewares,
+			prefix:       router.prefix + prefix,
+			engine:       router.engine,
+			middlewares:  append([]Middleware(nil), router.middlewares...),
+			errorHandler: router.errorHandler,
+			requirements: append([]requirement(nil), router.requirements...),
 		}
 	}
 }
 
+// newContext builds the request context with the group's middlewares and error handler
+func (router *JokerRouter) newContext(w http.ResponseWriter, r *http.Request, route *Route, finalHandler Middleware) *JokerContex {
+	ctx := router.engine.newContext(w, r, finalHandler, router.engine.middlewares, router.middlewares, route.chain)
+	ctx.errorHandler = router.errorHandler
+	return ctx
+}
+
 func (router *JokerRouter) Use(middleware Middleware) {
 	router.middlewares = append(router.middlewares, middleware)
 }
 
-func (router *JokerRouter) Map(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+func (router *JokerRouter) Map(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
 	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "*")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount_global := len(router.engine.middlewares)
-		middlewareCount_router := len(router.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount_global+middlewareCount_router+1),
-			index:            -1,
-			maxIndex:         middlewareCount_global + middlewareCount_router + 1,
-		}
-		copy(ctx.MiddlewareChains, router.engine.middlewares)
-		copy(ctx.MiddlewareChains[middlewareCount_global:], router.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			params := r.URL.Query()
-			status, response := handle(r, params, func(key, value string) {
-				w.Header().Set(key, value)
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
 			})
-			if response == nil {
-				w.WriteHeader(status)
-				return
-			}
-			jsonresult, err := json.Marshal(response)
-			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Server", "JokerHttp")
-			w.WriteHeader(status)
-			w.Write(jsonresult)
-		}
-		ctx.MiddlewareChains[middlewareCount_global+middlewareCount_router] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			router.engine.writeResponse(ctx, status, response)
 		}
+		router.newContext(w, r, route, finalHandler).run()
 	})
+	return route
 }
 
-func (router *JokerRouter) MapGet(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+func (router *JokerRouter) MapGet(pattern string, handle func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
 	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "GET")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount_global := len(router.engine.middlewares)
-		middlewareCount_router := len(router.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount_global+middlewareCount_router+1),
-			index:            -1,
-			maxIndex:         middlewareCount_global + middlewareCount_router + 1,
-		}
-		copy(ctx.MiddlewareChains, router.engine.middlewares)
-		copy(ctx.MiddlewareChains[middlewareCount_global:], router.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			if r.Method != http.MethodGet {
-				w.WriteHeader(405)
+			if ctx.Request.Method != http.MethodGet {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
 				return
 			}
-			params := r.URL.Query()
-			status, response := handle(r, params, func(key, value string) {
-				w.Header().Set(key, value)
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
 			})
-			if response == nil {
-				w.WriteHeader(status)
-				return
-			}
-			jsonresult, err := json.Marshal(response)
-			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Server", "JokerHttp")
-			w.WriteHeader(status)
-			w.Write(jsonresult)
-		}
-		ctx.MiddlewareChains[middlewareCount_global+middlewareCount_router] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			router.engine.writeResponse(ctx, status, response)
 		}
+		router.newContext(w, r, route, finalHandler).run()
 	})
+	return route
 }
 
-func (router *JokerRouter) MapPost(pattern string, handle func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+func (router *JokerRouter) MapPost(pattern string, handle func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
 	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "POST")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount_global := len(router.engine.middlewares)
-		middlewareCount_router := len(router.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount_global+middlewareCount_router+1),
-			index:            -1,
-			maxIndex:         middlewareCount_global + middlewareCount_router + 1,
-		}
-		copy(ctx.MiddlewareChains, router.engine.middlewares)
-		copy(ctx.MiddlewareChains[middlewareCount_global:], router.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			if r.Method != http.MethodPost {
-				w.WriteHeader(405)
+			if ctx.Request.Method != http.MethodPost {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
 				return
 			}
-			body := make([]byte, r.ContentLength)
-			_, err := r.Body.Read(body)
-			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
+			body, ok := router.engine.readBody(ctx)
+			if !ok {
 				return
 			}
-			defer r.Body.Close()
-			params := r.URL.Query()
-			status, response := handle(r, body, params, func(key, value string) {
-				w.Header().Set(key, value)
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, body, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
 			})
-			if response == nil {
-				w.WriteHeader(status)
+			router.engine.writeResponse(ctx, status, response)
+		}
+		router.newContext(w, r, route, finalHandler).run()
+	})
+	return route
+}
+
+// MapPostStream hands the decoded, size-limited body to the handler as a stream instead of reading it first
+func (router *JokerRouter) MapPostStream(pattern string, handle func(request *http.Request, body io.Reader, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "POST")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			if ctx.Request.Method != http.MethodPost {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
 				return
 			}
-			jsonresult, err := json.Marshal(response)
+			body, err := router.engine.requestBody(ctx.ResponseWriter, ctx.Request)
 			if err != nil {
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
+				ctx.HandleError(bodyErrorStatus(err), err)
 				return
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Server", "JokerHttp")
-			w.WriteHeader(status)
-			w.Write(jsonresult)
-		}
-		ctx.MiddlewareChains[middlewareCount_global+middlewareCount_router] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			defer body.Close()
+			params := ctx.Request.URL.Query()
+			status, response := handle(ctx.Request, body, params, func(key, value string) {
+				ctx.ResponseWriter.Header().Set(key, value)
+			})
+			router.engine.writeResponse(ctx, status, response)
 		}
+		router.newContext(w, r, route, finalHandler).run()
 	})
+	return route
 }
 
-func (router *JokerRouter) MapRedirect(pattern string, target string) {
+func (router *JokerRouter) MapRedirect(pattern string, target string) *Route {
 	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "*")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount_global := len(router.engine.middlewares)
-		middlewareCount_router := len(router.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount_global+middlewareCount_router+1),
-			index:            -1,
-			maxIndex:         middlewareCount_global + middlewareCount_router + 1,
-		}
-		copy(ctx.MiddlewareChains, router.engine.middlewares)
-		copy(ctx.MiddlewareChains[middlewareCount_global:], router.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			http.Redirect(w, r, target, http.StatusFound)
-		}
-		ctx.MiddlewareChains[middlewareCount_global+middlewareCount_router] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+			http.Redirect(ctx.ResponseWriter, ctx.Request, target, http.StatusFound)
 		}
+		router.newContext(w, r, route, finalHandler).run()
 	})
+	return route
 }
 
-func (router *JokerRouter) MapReverseProxy(pattern string, target string) {
+func (router *JokerRouter) MapReverseProxy(pattern string, target string) *Route {
 	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "*")
 	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		middlewareCount_global := len(router.engine.middlewares)
-		middlewareCount_router := len(router.middlewares)
-		ctx := &JokerContex{
-			Request:          r,
-			ResponseWriter:   w,
-			MiddlewareChains: make([]Middleware, middlewareCount_global+middlewareCount_router+1),
-			index:            -1,
-			maxIndex:         middlewareCount_global + middlewareCount_router + 1,
-		}
-		copy(ctx.MiddlewareChains, router.engine.middlewares)
-		copy(ctx.MiddlewareChains[middlewareCount_global:], router.middlewares)
 		finalHandler := func(ctx *JokerContex) {
-			proxy, err := newProxy(target)
+			proxy, err := router.engine.newProxy(target, pattern)
 			if err != nil {
-				ctx.ResponseWriter.WriteHeader(500)
-				log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
+				ctx.HandleError(http.StatusInternalServerError, err)
 				return
 			}
 			proxy.ServeHTTP(ctx.ResponseWriter, ctx.Request)
 		}
-		ctx.MiddlewareChains[middlewareCount_global+middlewareCount_router] = finalHandler
-		if len(ctx.MiddlewareChains) > 0 {
-			ctx.Next()
+		router.newContext(w, r, route, finalHandler).run()
+	})
+	return route
+}
+
+func (router *JokerRouter) MapSSE(pattern string, handle func(request *http.Request, stream *SSEStream)) *Route {
+	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "GET")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			if ctx.Request.Method != http.MethodGet {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
+				return
+			}
+			router.engine.serveSSE(ctx, handle)
+		}
+		router.newContext(w, r, route, finalHandler).run()
+	})
+	return route
+}
+
+func (router *JokerRouter) MapWebSocket(pattern string, handle func(request *http.Request, conn *WebSocketConn)) *Route {
+	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "GET")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			router.engine.serveWebSocket(ctx, pattern, handle)
+		}
+		router.newContext(w, r, route, finalHandler).run()
+	})
+	return route
+}
+
+func (router *JokerRouter) MapUpload(pattern string, options UploadOptions, handle func(request *http.Request, form *UploadForm, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	pattern = router.prefix + pattern
+	route := router.engine.addRoute(router, pattern, "POST, PUT")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			router.engine.serveUpload(ctx, options, handle)
 		}
+		router.newContext(w, r, route, finalHandler).run()
 	})
+	return route
 }
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+)
+
+// Route is returned by the Map functions; requirements added to it apply to that route only,
+// e.g. router.MapGet("/reports", handler).Require("auditor")
+type Route struct {
+	Pattern      string
+	Method       string
+	router       *JokerRouter
+	requirements []requirement
+	chain        []Middleware
+}
+
+// RouteInfo describes a route and everything it requires, for audits
+type RouteInfo struct {
+	Pattern     string   `json:"pattern"`
+	Method      string   `json:"method"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Policies    []string `json:"policies,omitempty"`
+}
+
+type jokerRoutes struct {
+	mu     sync.RWMutex
+	routes []*Route
+}
+
+func (jokerEngine *JokerEngine) addRoute(router *JokerRouter, pattern string, method string) *Route {
+	if jokerEngine.Routes == nil {
+		jokerEngine.Routes = &jokerRoutes{}
+	}
+	route := &Route{Pattern: pattern, Method: method, router: router}
+	route.chain = []Middleware{route.authorize}
+	jokerEngine.Routes.mu.Lock()
+	defer jokerEngine.Routes.mu.Unlock()
+	jokerEngine.Routes.routes = append(jokerEngine.Routes.routes, route)
+	return route
+}
+
+// Introspect lists the registered routes with the roles, permissions and policies of their groups and their own
+func (routes *jokerRoutes) Introspect() []RouteInfo {
+	routes.mu.RLock()
+	defer routes.mu.RUnlock()
+	infos := make([]RouteInfo, 0, len(routes.routes))
+	for _, route := range routes.routes {
+		info := RouteInfo{Pattern: route.Pattern, Method: route.Method}
+		var requirements []requirement
+		if route.router != nil {
+			requirements = append(requirements, route.router.requirements...)
+		}
+		for _, req := range append(requirements, route.requirements...) {
+			info.Roles = append(info.Roles, req.roles...)
+			info.Permissions = append(info.Permissions, req.permissions...)
+			if req.policy != "" {
+				info.Policies = append(info.Policies, req.policy)
+			}
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Pattern < infos[j].Pattern
+	})
+	return infos
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetServerHeader replaces the "Server: JokerHttp" header of responses; an empty name removes it
+func (jokerEngine *JokerEngine) SetServerHeader(name string) {
+	jokerEngine.serverHeader = &name
+}
+
+func (jokerEngine *JokerEngine) serverName() string {
+	if jokerEngine == nil || jokerEngine.serverHeader == nil {
+		return "JokerHttp"
+	}
+	return *jokerEngine.serverHeader
+}
+
+func (jokerEngine *JokerEngine) setServerHeader(header http.Header) {
+	if name := jokerEngine.serverName(); name != "" {
+		header.Set("Server", name)
+	} else {
+		header.Del("Server")
+	}
+}
+
+// SecurityHeadersOptions lists the headers to send; empty values are left out
+type SecurityHeadersOptions struct {
+	// HSTSMaxAge enables Strict-Transport-Security on HTTPS requests
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ContentSecurityPolicy may contain {nonce}, replaced by a fresh nonce on every request,
+	// e.g. "script-src 'self' 'nonce-{nonce}'"
+	ContentSecurityPolicy string
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only
+	CSPReportOnly             bool
+	ContentTypeNosniff        bool
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+}
+
+// StrictSecurityHeaders suits applications that serve all their own scripts and styles
+func StrictSecurityHeaders() SecurityHeadersOptions {
+	return SecurityHeadersOptions{
+		HSTSMaxAge:                2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		HSTSPreload:               true,
+		ContentSecurityPolicy:     "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'",
+		ContentTypeNosniff:        true,
+		ReferrerPolicy:            "no-referrer",
+		PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginEmbedderPolicy: "require-corp",
+	}
+}
+
+// RelaxedSecurityHeaders allows inline styles, images from any HTTPS origin, popups and cross-origin embeds
+func RelaxedSecurityHeaders() SecurityHeadersOptions {
+	return SecurityHeadersOptions{
+		HSTSMaxAge:              180 * 24 * time.Hour,
+		ContentSecurityPolicy:   "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'unsafe-inline'; img-src 'self' data: https:; object-src 'none'; frame-ancestors 'self'",
+		ContentTypeNosniff:      true,
+		ReferrerPolicy:          "strict-origin-when-cross-origin",
+		PermissionsPolicy:       "camera=(), microphone=(), geolocation=()",
+		CrossOriginOpenerPolicy: "same-origin-allow-popups",
+	}
+}
+
+const cspNonceKey = "cspNonce"
+
+// SecurityHeaders sets the headers before the handler runs, so error responses carry them too
+func SecurityHeaders(options SecurityHeadersOptions) Middleware {
+	var hsts string
+	if options.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(options.HSTSMaxAge/time.Second), 10)
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	cspHeader := "Content-Security-Policy"
+	if options.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	needsNonce := strings.Contains(options.ContentSecurityPolicy, "{nonce}")
+	static := map[string]string{
+		"Referrer-Policy":              options.ReferrerPolicy,
+		"Permissions-Policy":           options.PermissionsPolicy,
+		"Cross-Origin-Opener-Policy":   options.CrossOriginOpenerPolicy,
+		"Cross-Origin-Embedder-Policy": options.CrossOriginEmbedderPolicy,
+	}
+	if options.ContentTypeNosniff {
+		static["X-Content-Type-Options"] = "nosniff"
+	}
+	return func(ctx *JokerContex) {
+		header := ctx.ResponseWriter.Header()
+		for name, value := range static {
+			if value != "" {
+				header.Set(name, value)
+			}
+		}
+		if hsts != "" && ctx.Scheme() == "https" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		if csp := options.ContentSecurityPolicy; csp != "" {
+			if needsNonce {
+				nonce := make([]byte, 16)
+				rand.Read(nonce)
+				encoded := base64.RawURLEncoding.EncodeToString(nonce)
+				ctx.Set(cspNonceKey, encoded)
+				csp = strings.ReplaceAll(csp, "{nonce}", encoded)
+			}
+			header.Set(cspHeader, csp)
+		}
+		ctx.Next()
+	}
+}
+
+// CSPNonce is this request's Content-Security-Policy nonce, for <script nonce="..."> tags;
+// templates use {{cspNonce}}
+func (ctx *JokerContex) CSPNonce() string {
+	nonce, _ := Value[string](ctx, cspNonceKey)
+	return nonce
+}
@@ -0,0 +1,219 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SessionData is what stores persist; values are gob-encoded by the file and cookie stores,
+// so custom types must be registered with gob.Register
+type SessionData struct {
+	ID        string
+	Values    map[string]interface{}
+	ExpiresAt time.Time
+}
+
+// SessionStore persists sessions; the cookie holds whatever Save returns
+type SessionStore interface {
+	// Load returns nil, nil when the session is missing or expired
+	Load(cookie string) (*SessionData, error)
+	Save(data *SessionData) (cookie string, err error)
+	Delete(id string) error
+}
+
+type SessionOptions struct {
+	// Store defaults to NewMemorySessionStore on the engine's Cache
+	Store SessionStore
+	// CookieName defaults to "joker_session"
+	CookieName string
+	// MaxAge is how long a session lives, 24 hours when zero
+	MaxAge time.Duration
+	// Sliding extends the session on use instead of expiring MaxAge after it was created
+	Sliding  bool
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+type Session struct {
+	mu        sync.Mutex
+	data      SessionData
+	modified  bool
+	destroyed bool
+	isNew     bool
+	staleIDs  []string
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.ID
+}
+
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data.Values[key]
+	return value, ok
+}
+
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// Regenerate gives the session a new ID, e.g. on login to prevent session fixation
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew {
+		s.staleIDs = append(s.staleIDs, s.data.ID)
+	}
+	s.data.ID = newSessionID()
+	s.isNew = true
+	s.modified = true
+}
+
+// Destroy removes the session from the store and the client, e.g. on logout
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values = map[string]interface{}{}
+	s.destroyed = true
+}
+
+// SessionValue returns the session value under key if it has type T
+func SessionValue[T any](session *Session, key string) (T, bool) {
+	var zero T
+	if session == nil {
+		return zero, false
+	}
+	raw, ok := session.Get(key)
+	if !ok {
+		return zero, false
+	}
+	value, ok := raw.(T)
+	return value, ok
+}
+
+const sessionContextKey = "session"
+
+// Session returns the request's session, or nil when the Sessions middleware is not in use
+func (ctx *JokerContex) Session() *Session {
+	session, _ := Value[*Session](ctx, sessionContextKey)
+	return session
+}
+
+// Sessions loads the session from its cookie and saves it before the response is written,
+// only when it changed or a sliding session needs extending
+func Sessions(options SessionOptions) Middleware {
+	if options.CookieName == "" {
+		options.CookieName = "joker_session"
+	}
+	if options.MaxAge <= 0 {
+		options.MaxAge = 24 * time.Hour
+	}
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	// The default store needs the engine's cache, so it is made on the first request
+	var defaultOnce sync.Once
+	var defaultStore SessionStore
+	return func(ctx *JokerContex) {
+		store := options.Store
+		if store == nil {
+			defaultOnce.Do(func() {
+				defaultStore = NewMemorySessionStore(ctx.engine.Cache)
+			})
+			store = defaultStore
+		}
+		session := &Session{}
+		if cookie, err := ctx.Request.Cookie(options.CookieName); err == nil {
+			data, err := store.Load(cookie.Value)
+			if err != nil {
+				log.Println("[Error]:Handle in " + ctx.Request.URL.Path + " >>> session: " + err.Error())
+			}
+			if data != nil {
+				session.data = *data
+			}
+		}
+		if session.data.ID == "" {
+			session.data = SessionData{ID: newSessionID(), Values: map[string]interface{}{}, ExpiresAt: time.Now().Add(options.MaxAge)}
+			session.isNew = true
+		}
+		ctx.Set(sessionContextKey, session)
+
+		saved := false
+		save := func(int) {
+			if !saved {
+				saved = true
+				options.save(ctx, store, session)
+			}
+		}
+		ctx.BeforeWrite(save)
+		ctx.Next()
+		if !ctx.Written() {
+			save(0)
+		}
+	}
+}
+
+func (options SessionOptions) save(ctx *JokerContex, store SessionStore, session *Session) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, id := range session.staleIDs {
+		store.Delete(id)
+	}
+	cookie := &CookieOptions{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		Secure:   options.Secure,
+		SameSite: options.SameSite,
+	}
+	if session.destroyed {
+		if !session.isNew {
+			store.Delete(session.data.ID)
+			ctx.DeleteCookie(options.CookieName, cookie)
+		}
+		return
+	}
+	now := time.Now()
+	// A sliding session is extended once half of its lifetime has passed, so most requests write nothing
+	extend := options.Sliding && !session.isNew && session.data.ExpiresAt.Sub(now) < options.MaxAge/2
+	if !session.modified && !extend {
+		return
+	}
+	if options.Sliding || session.isNew {
+		session.data.ExpiresAt = now.Add(options.MaxAge)
+	}
+	value, err := store.Save(&session.data)
+	if err == nil {
+		cookie.MaxAge = session.data.ExpiresAt.Sub(now)
+		err = ctx.SetCookie(options.CookieName, value, cookie)
+	}
+	if err != nil {
+		log.Println("[Error]:Handle in " + ctx.Request.URL.Path + " >>> session: " + err.Error())
+	}
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func copySessionData(data *SessionData) *SessionData {
+	copied := *data
+	copied.Values = make(map[string]interface{}, len(data.Values))
+	for key, value := range data.Values {
+		copied.Values[key] = value
+	}
+	return &copied
+}
+
+func encodeSessionData(data *SessionData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSessionData(raw []byte) (*SessionData, error) {
+	var data SessionData
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.Values == nil {
+		data.Values = map[string]interface{}{}
+	}
+	return &data, nil
+}
+
+type memorySessionStore struct {
+	cache *jokerCache
+}
+
+// NewMemorySessionStore keeps sessions in cache, which drops them when they expire
+func NewMemorySessionStore(cache *jokerCache) SessionStore {
+	return &memorySessionStore{cache: cache}
+}
+
+func (store *memorySessionStore) Load(cookie string) (*SessionData, error) {
+	value, ok := store.cache.TryGet("session:" + cookie)
+	if !ok {
+		return nil, nil
+	}
+	data := value.(*SessionData)
+	if time.Now().After(data.ExpiresAt) {
+		return nil, nil
+	}
+	// Each request works on its own copy until it saves
+	return copySessionData(data), nil
+}
+
+func (store *memorySessionStore) Save(data *SessionData) (string, error) {
+	store.cache.Set("session:"+data.ID, copySessionData(data), data.ExpiresAt.Unix()+1)
+	return data.ID, nil
+}
+
+func (store *memorySessionStore) Delete(id string) error {
+	store.cache.Remove("session:" + id)
+	return nil
+}
+
+type fileSessionStore struct {
+	dir       string
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileSessionStore keeps one file per session in dir; expired files are removed as they are found
+func NewFileSessionStore(dir string) (SessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileSessionStore{dir: dir, lastSweep: time.Now()}, nil
+}
+
+func (store *fileSessionStore) path(id string) (string, bool) {
+	// IDs come from cookies, so only accept what newSessionID produces
+	if len(id) != 43 || strings.Trim(id, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return "", false
+	}
+	return filepath.Join(store.dir, "sess_"+id), true
+}
+
+func (store *fileSessionStore) Load(cookie string) (*SessionData, error) {
+	path, ok := store.path(cookie)
+	if !ok {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeSessionData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(data.ExpiresAt) {
+		os.Remove(path)
+		return nil, nil
+	}
+	return data, nil
+}
+
+func (store *fileSessionStore) Save(data *SessionData) (string, error) {
+	path, ok := store.path(data.ID)
+	if !ok {
+		return "", errors.New("invalid session id")
+	}
+	raw, err := encodeSessionData(data)
+	if err != nil {
+		return "", err
+	}
+	// Write to a temp file first so a concurrent Load never sees half a session
+	tmp, err := os.CreateTemp(store.dir, "tmp_*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(raw)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	store.sweep()
+	return data.ID, nil
+}
+
+func (store *fileSessionStore) Delete(id string) error {
+	path, ok := store.path(id)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// sweep removes expired sessions at most once an hour
+func (store *fileSessionStore) sweep() {
+	store.mu.Lock()
+	if time.Since(store.lastSweep) < time.Hour {
+		store.mu.Unlock()
+		return
+	}
+	store.lastSweep = time.Now()
+	store.mu.Unlock()
+	go func() {
+		paths, _ := filepath.Glob(filepath.Join(store.dir, "sess_*"))
+		for _, path := range paths {
+			store.Load(strings.TrimPrefix(filepath.Base(path), "sess_"))
+		}
+	}()
+}
+
+type cookieSessionStore struct {
+	keyring *Keyring
+}
+
+// NewCookieSessionStore keeps the whole session in the cookie, encrypted with the keyring's current key;
+// sessions must stay small, the cookie is limited to 4096 bytes
+func NewCookieSessionStore(keyring *Keyring) SessionStore {
+	return &cookieSessionStore{keyring: keyring}
+}
+
+// cookieSessionPurpose binds sealed sessions to this store, so no other encrypted cookie can pass for one
+const cookieSessionPurpose = "joker-session"
+
+func (store *cookieSessionStore) Load(cookie string) (*SessionData, error) {
+	raw, ok := store.keyring.Decrypt(cookieSessionPurpose, cookie)
+	if !ok {
+		// Tampered with or sealed under a key that has been dropped
+		return nil, nil
+	}
+	data, err := decodeSessionData(raw)
+	if err != nil || time.Now().After(data.ExpiresAt) {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (store *cookieSessionStore) Save(data *SessionData) (string, error) {
+	raw, err := encodeSessionData(data)
+	if err != nil {
+		return "", err
+	}
+	return store.keyring.Encrypt(cookieSessionPurpose, raw)
+}
+
+// Delete has nothing to remove, the cookie is expired by the middleware
+func (store *cookieSessionStore) Delete(string) error {
+	return nil
+}
@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStreamClosed         = errors.New("event stream closed")
+	errStreamingUnsupported = errors.New("streaming unsupported")
+)
+
+// SSEStream is handed to MapSSE handlers to push events to one client
+type SSEStream struct {
+	Request     *http.Request
+	writer      http.ResponseWriter
+	flusher     http.Flusher
+	codec       func(v interface{}) ([]byte, error)
+	lastEventID string
+	ctx         context.Context
+	mu          sync.Mutex
+}
+
+type SSEEvent struct {
+	Event string
+	ID    string
+	Data  interface{}
+}
+
+func (jokerEngine *JokerEngine) SetSSEKeepAlive(interval time.Duration) {
+	jokerEngine.sseKeepAlive = interval
+}
+
+// LastEventID is the id the client saw last before reconnecting, if any
+func (stream *SSEStream) LastEventID() string {
+	return stream.lastEventID
+}
+
+// Context is cancelled when the client disconnects or the engine shuts down
+func (stream *SSEStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *SSEStream) Done() <-chan struct{} {
+	return stream.ctx.Done()
+}
+
+// Send writes one event; data is sent as-is for strings and []byte, otherwise JSON encoded
+func (stream *SSEStream) Send(event, id string, data interface{}) error {
+	var payload string
+	switch d := data.(type) {
+	case string:
+		payload = d
+	case []byte:
+		payload = string(d)
+	default:
+		encoded, err := stream.codec(data)
+		if err != nil {
+			return err
+		}
+		payload = string(encoded)
+	}
+	var sb strings.Builder
+	if id != "" {
+		sb.WriteString("id: " + sanitizeSSEField(id) + "\n")
+	}
+	if event != "" {
+		sb.WriteString("event: " + sanitizeSSEField(event) + "\n")
+	}
+	// A lone \r ends a line too, left alone it would let data start a new field
+	payload = strings.ReplaceAll(strings.ReplaceAll(payload, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(payload, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+	return stream.write(sb.String())
+}
+
+func (stream *SSEStream) SendEvent(event SSEEvent) error {
+	return stream.Send(event.Event, event.ID, event.Data)
+}
+
+// Comment writes a comment line, which clients ignore
+func (stream *SSEStream) Comment(text string) error {
+	return stream.write(": " + sanitizeSSEField(text) + "\n\n")
+}
+
+// SetRetry tells the client how long to wait before reconnecting
+func (stream *SSEStream) SetRetry(retry time.Duration) error {
+	return stream.write("retry: " + strconv.FormatInt(retry.Milliseconds(), 10) + "\n\n")
+}
+
+func (stream *SSEStream) write(s string) error {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.ctx.Err() != nil {
+		return ErrStreamClosed
+	}
+	if _, err := stream.writer.Write([]byte(s)); err != nil {
+		return err
+	}
+	stream.flusher.Flush()
+	return nil
+}
+
+func sanitizeSSEField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// serveSSE switches the response to an event stream and runs handle until it returns
+func (jokerEngine *JokerEngine) serveSSE(ctx *JokerContex, handle func(request *http.Request, stream *SSEStream)) {
+	w := ctx.ResponseWriter
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ctx.HandleError(http.StatusInternalServerError, errStreamingUnsupported)
+		return
+	}
+	defer ctx.withoutRequestTimeout()()
+	streamCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+	stream := &SSEStream{
+		Request:     ctx.Request,
+		writer:      w,
+		flusher:     flusher,
+		codec:       jokerEngine.JSONCodec().Marshal,
+		lastEventID: ctx.Request.Header.Get("Last-Event-ID"),
+		ctx:         streamCtx,
+	}
+	if stream.lastEventID == "" {
+		stream.lastEventID = ctx.Request.URL.Query().Get("lastEventId")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	ctx.engine.setServerHeader(w.Header())
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := jokerEngine.sseKeepAlive
+	if keepAlive == 0 {
+		keepAlive = 15 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				stream.Comment("keep-alive")
+			case <-jokerEngine.shutdownSignal():
+				cancel()
+				return
+			case <-streamCtx.Done():
+				return
+			}
+		}
+	}()
+	handle(stream.Request, stream)
+	// Stop the keep-alive before the handler's writer goes away
+	stream.mu.Lock()
+	cancel()
+	stream.mu.Unlock()
+}
+
+func (jokerEngine *JokerEngine) MapSSE(pattern string, handle func(request *http.Request, stream *SSEStream)) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "GET")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			if ctx.Request.Method != http.MethodGet {
+				ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
+				return
+			}
+			jokerEngine.serveSSE(ctx, handle)
+		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
+	})
+	return route
+}
+
+// SSEBroker fans published events out to every subscriber of a topic
+type SSEBroker struct {
+	mu          sync.RWMutex
+	topics      map[string]map[chan SSEEvent]struct{}
+	history     map[string][]SSEEvent
+	historySize int
+	bufferSize  int
+}
+
+// NewSSEBroker keeps the last historySize events per topic to replay after Last-Event-ID
+func NewSSEBroker(historySize int) *SSEBroker {
+	return &SSEBroker{
+		topics:      make(map[string]map[chan SSEEvent]struct{}),
+		history:     make(map[string][]SSEEvent),
+		historySize: historySize,
+		bufferSize:  16,
+	}
+}
+
+// Subscribe returns a channel of events for the topic and a function that ends the subscription
+func (broker *SSEBroker) Subscribe(topic string) (<-chan SSEEvent, func()) {
+	ch, _, unsubscribe := broker.subscribe(topic, "")
+	return ch, unsubscribe
+}
+
+// subscribe also returns the events missed since lastEventID; both are taken under one lock,
+// so an event is either replayed or delivered on the channel, never both
+func (broker *SSEBroker) subscribe(topic, lastEventID string) (<-chan SSEEvent, []SSEEvent, func()) {
+	ch := make(chan SSEEvent, broker.bufferSize)
+	broker.mu.Lock()
+	missed := broker.since(topic, lastEventID)
+	if broker.topics[topic] == nil {
+		broker.topics[topic] = make(map[chan SSEEvent]struct{})
+	}
+	broker.topics[topic][ch] = struct{}{}
+	broker.mu.Unlock()
+	var once sync.Once
+	return ch, missed, func() {
+		once.Do(func() {
+			broker.mu.Lock()
+			delete(broker.topics[topic], ch)
+			if len(broker.topics[topic]) == 0 {
+				delete(broker.topics, topic)
+			}
+			broker.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish delivers the event to all subscribers; slow subscribers miss events instead of blocking
+func (broker *SSEBroker) Publish(topic string, event SSEEvent) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.historySize > 0 && event.ID != "" {
+		history := append(broker.history[topic], event)
+		if len(history) > broker.historySize {
+			history = history[len(history)-broker.historySize:]
+		}
+		broker.history[topic] = history
+	}
+	for ch := range broker.topics[topic] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (broker *SSEBroker) Subscribers(topic string) int {
+	broker.mu.RLock()
+	defer broker.mu.RUnlock()
+	return len(broker.topics[topic])
+}
+
+// since returns the events published after lastEventID, or nil when it is unknown; broker.mu must be held
+func (broker *SSEBroker) since(topic, lastEventID string) []SSEEvent {
+	if lastEventID == "" {
+		return nil
+	}
+	history := broker.history[topic]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == lastEventID {
+			return append([]SSEEvent(nil), history[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// Serve streams the topic to the client until it disconnects, replaying missed events first
+func (broker *SSEBroker) Serve(stream *SSEStream, topic string) error {
+	events, missed, unsubscribe := broker.subscribe(topic, stream.LastEventID())
+	defer unsubscribe()
+	for _, event := range missed {
+		if err := stream.SendEvent(event); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case event := <-events:
+			if err := stream.SendEvent(event); err != nil {
+				return err
+			}
+		case <-stream.Done():
+			return nil
+		}
+	}
+}
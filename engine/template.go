@@ -0,0 +1,301 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Template files under layouts/ and partials/ (or named _*.html) are shared by every page.
+// Each page is parsed together with them, so pages can fill in layout blocks with {{define}}.
+type jokerTemplates struct {
+	mu      sync.RWMutex
+	sources []templateSource
+	funcs   template.FuncMap
+	layout  string
+	pages   map[string]*template.Template
+	stamp   string
+}
+
+type templateSource struct {
+	fsys fs.FS
+	root string
+}
+
+var templateExtensions = []string{".html", ".tmpl", ".gohtml"}
+
+func (jokerEngine *JokerEngine) templateSet() *jokerTemplates {
+	if jokerEngine.templates == nil {
+		jokerEngine.templates = &jokerTemplates{funcs: template.FuncMap{}}
+	}
+	return jokerEngine.templates
+}
+
+// LoadTemplates parses every template file found under dir
+func (jokerEngine *JokerEngine) LoadTemplates(dir string) error {
+	dir = strings.ReplaceAll(dir, "\\", "/")
+	return jokerEngine.LoadTemplatesFS(os.DirFS(dir), ".")
+}
+
+// LoadTemplatesFS parses every template file found under root in fsys, e.g. an embed.FS
+func (jokerEngine *JokerEngine) LoadTemplatesFS(fsys fs.FS, root string) error {
+	set := jokerEngine.templateSet()
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.sources = append(set.sources, templateSource{fsys: fsys, root: root})
+	return set.parse()
+}
+
+// AddTemplateFuncs makes funcs available to all templates; they are re-parsed on the next render
+func (jokerEngine *JokerEngine) AddTemplateFuncs(funcs template.FuncMap) {
+	set := jokerEngine.templateSet()
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for name, fn := range funcs {
+		set.funcs[name] = fn
+	}
+	set.pages = nil
+}
+
+// SetTemplateLayout executes the named layout for every page instead of the page itself
+func (jokerEngine *JokerEngine) SetTemplateLayout(layout string) {
+	set := jokerEngine.templateSet()
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.layout = layout
+}
+
+// SetDevMode re-parses templates whenever their files change, instead of caching them
+func (jokerEngine *JokerEngine) SetDevMode(dev bool) {
+	jokerEngine.devMode = dev
+}
+
+func isTemplateFile(name string) bool {
+	for _, ext := range templateExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSharedTemplate(name string) bool {
+	return strings.HasPrefix(name, "layouts/") || strings.HasPrefix(name, "partials/") ||
+		strings.Contains(name, "/layouts/") || strings.Contains(name, "/partials/") ||
+		strings.HasPrefix(path.Base(name), "_")
+}
+
+type templateFile struct {
+	source  templateSource
+	path    string
+	name    string
+	modTime time.Time
+}
+
+func (set *jokerTemplates) files() ([]templateFile, error) {
+	var files []templateFile
+	for _, source := range set.sources {
+		err := fs.WalkDir(source.fsys, source.root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !isTemplateFile(p) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			name := strings.TrimPrefix(strings.TrimPrefix(p, source.root), "/")
+			if source.root == "." {
+				name = p
+			}
+			files = append(files, templateFile{source: source, path: p, name: name, modTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+func templateStamp(files []templateFile) string {
+	var sb strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sb, "%s@%d;", f.name, f.modTime.UnixNano())
+	}
+	return sb.String()
+}
+
+// parse must be called with set.mu held
+func (set *jokerTemplates) parse() error {
+	files, err := set.files()
+	if err != nil {
+		return err
+	}
+	base := template.New("").Funcs(requestTemplateFuncs()).Funcs(set.funcs)
+	var pages []templateFile
+	for _, f := range files {
+		if !isSharedTemplate(f.name) {
+			pages = append(pages, f)
+			continue
+		}
+		if err := parseTemplateFile(base, f); err != nil {
+			return err
+		}
+	}
+	parsed := make(map[string]*template.Template, len(pages))
+	for _, f := range pages {
+		page, err := base.Clone()
+		if err != nil {
+			return err
+		}
+		if err := parseTemplateFile(page, f); err != nil {
+			return err
+		}
+		parsed[f.name] = page
+	}
+	set.pages = parsed
+	set.stamp = templateStamp(files)
+	return nil
+}
+
+func parseTemplateFile(t *template.Template, f templateFile) error {
+	content, err := fs.ReadFile(f.source.fsys, f.path)
+	if err != nil {
+		return err
+	}
+	if _, err := t.New(f.name).Parse(string(content)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (set *jokerTemplates) lookup(name string, dev bool) (*template.Template, string, error) {
+	set.mu.RLock()
+	page, layout, stale := set.pages[name], set.layout, set.pages == nil
+	stamp := set.stamp
+	set.mu.RUnlock()
+	if dev && !stale {
+		files, err := set.files()
+		if err != nil {
+			return nil, "", err
+		}
+		stale = templateStamp(files) != stamp
+	}
+	if stale {
+		set.mu.Lock()
+		err := set.parse()
+		page, layout = set.pages[name], set.layout
+		set.mu.Unlock()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if page == nil {
+		return nil, "", fmt.Errorf("template %q not found", name)
+	}
+	if layout == "" || page.Lookup(layout) == nil {
+		layout = name
+	}
+	return page, layout, nil
+}
+
+// Request-bound funcs cannot be bound per execution without cloning, which re-runs the escaper
+// on every render. They are registered once and output a marker instead, made of characters every
+// escaping context leaves alone, and the markers are replaced with the request's values afterwards.
+var templateMarker = func() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "jokertpl" + hex.EncodeToString(b)
+}()
+
+var (
+	csrfTokenMarker = templateMarker + "csrftoken"
+	// The trailing "<" is escaped in every context but HTML text, so only there is the marker found
+	// verbatim; the field markup is never put inside an attribute, script or URL
+	csrfFieldMarker = templateMarker + "csrffield<"
+	cspNonceMarker  = templateMarker + "cspnonce"
+)
+
+// requestTemplateFuncs are the built-in funcs that depend on the request being rendered
+func requestTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string { return csrfTokenMarker },
+		"csrfField": func() template.HTML { return template.HTML(csrfFieldMarker) },
+		"cspNonce":  func() string { return cspNonceMarker },
+	}
+}
+
+var errCSRFFieldContext = errors.New("csrfField can only be used in HTML text, use csrfToken inside attributes and scripts")
+
+// expandRequestMarkers fills in the values of request-bound funcs; ctx may be nil
+func expandRequestMarkers(out []byte, ctx *JokerContex) ([]byte, error) {
+	if !bytes.Contains(out, []byte(templateMarker)) {
+		return out, nil
+	}
+	value := func(marker string, get func() string) {
+		if bytes.Contains(out, []byte(marker)) {
+			var v string
+			if ctx != nil {
+				v = get()
+			}
+			out = bytes.ReplaceAll(out, []byte(marker), []byte(v))
+		}
+	}
+	value(csrfFieldMarker, func() string { return string(ctx.csrfField()) })
+	if bytes.Contains(out, []byte(strings.TrimSuffix(csrfFieldMarker, "<"))) {
+		return nil, errCSRFFieldContext
+	}
+	value(csrfTokenMarker, ctx.CSRFToken)
+	value(cspNonceMarker, ctx.CSPNonce)
+	return out, nil
+}
+
+type htmlResponse struct {
+	engine *JokerEngine
+	name   string
+	data   interface{}
+}
+
+func (h htmlResponse) Respond(w http.ResponseWriter, r *http.Request, status int) error {
+	if h.engine.templates == nil {
+		return fmt.Errorf("template %q not found: no templates loaded", h.name)
+	}
+	page, entry, err := h.engine.templates.lookup(h.name, h.engine.devMode)
+	if err != nil {
+		return err
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := page.ExecuteTemplate(buf, entry, h.data); err != nil {
+		return err
+	}
+	out, err := expandRequestMarkers(buf.Bytes(), GetContext(r))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	h.engine.setServerHeader(w.Header())
+	w.WriteHeader(status)
+	w.Write(out)
+	return nil
+}
+
+// HTML renders the named template; handlers return its result directly
+func (ctx *JokerContex) HTML(status int, name string, data interface{}) (int, interface{}) {
+	return status, htmlResponse{engine: ctx.engine, name: name, data: data}
+}
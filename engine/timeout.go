@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// SetRequestTimeout puts a deadline on every request's context; zero means no deadline.
+// Event streams and WebSocket connections are exempt, use Timeout on those routes to limit them.
+func (jokerEngine *JokerEngine) SetRequestTimeout(timeout time.Duration) {
+	jokerEngine.requestTimeout = timeout
+}
+
+// Timeout puts a deadline on the request's context for the routes it is used on,
+// e.g. router.Group("/reports").Use(engine.Timeout(30 * time.Second))
+func Timeout(timeout time.Duration) Middleware {
+	return func(ctx *JokerContex) {
+		deadlineCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(deadlineCtx)
+		ctx.Next()
+	}
+}
+
+// errEngineDeadline is the cause of SetRequestTimeout's deadline, which streams ignore
+var errEngineDeadline = errors.New("request timeout")
+
+// streamContext keeps the request's values but takes cancellation from a context without the engine deadline
+type streamContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c streamContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+// withoutRequestTimeout lifts the SetRequestTimeout deadline for a long-lived stream. The client going
+// away and deadlines set by middleware such as Timeout still end it. The returned func releases it.
+func (ctx *JokerContex) withoutRequestTimeout() func() {
+	if ctx.untimed == nil {
+		return func() {}
+	}
+	current := ctx.Request.Context()
+	detached, cancel := context.WithCancelCause(ctx.untimed)
+	stop := context.AfterFunc(current, func() {
+		if cause := context.Cause(current); cause != errEngineDeadline {
+			cancel(cause)
+		}
+	})
+	ctx.Request = ctx.Request.WithContext(streamContext{Context: detached, values: current})
+	return func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Context is cancelled when the client goes away, the deadline passes or the request completes
+func (ctx *JokerContex) Context() context.Context {
+	return ctx.Request.Context()
+}
+
+// proxyErrorHandler reports upstream timeouts as 504 and stays quiet when the client left
+func proxyErrorHandler(pattern string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			return
+		}
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		if ctx := GetContext(r); ctx != nil {
+			ctx.HandleError(status, err)
+			return
+		}
+		log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
+		w.WriteHeader(status)
+	}
+}
@@ -0,0 +1,350 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const defaultMultipartMemory = 32 << 20
+
+var (
+	ErrFileTooLarge       = errors.New("uploaded file too large")
+	ErrUploadTooLarge     = errors.New("upload too large")
+	ErrFileTypeNotAllowed = errors.New("uploaded file type not allowed")
+)
+
+type UploadOptions struct {
+	// MemoryThreshold is the size above which a file is spooled to disk, 1 MiB when zero
+	MemoryThreshold int64
+	// MaxFileSize limits each file, unlimited when zero
+	MaxFileSize int64
+	// MaxTotalSize limits all parts together, the engine's body limit when zero
+	MaxTotalSize int64
+	// AllowedTypes are sniffed MIME types such as "image/png" or "image/*"; empty allows all
+	AllowedTypes []string
+	// TempDir is where large files are spooled, os.TempDir() when empty
+	TempDir string
+}
+
+type UploadedFile struct {
+	FieldName string
+	Filename  string
+	// ContentType is sniffed from the content, not taken from the client
+	ContentType string
+	Size        int64
+	Header      textproto.MIMEHeader
+	data        []byte
+	path        string
+}
+
+// Open returns the file content, from memory or from its temp file
+func (file *UploadedFile) Open() (io.ReadCloser, error) {
+	if file.path != "" {
+		return os.Open(file.path)
+	}
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// SaveTo copies the file to path
+func (file *UploadedFile) SaveTo(path string) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// InMemory reports whether the file stayed below the memory threshold
+func (file *UploadedFile) InMemory() bool {
+	return file.path == ""
+}
+
+type UploadForm struct {
+	Values url.Values
+	Files  map[string][]*UploadedFile
+}
+
+// File returns the first file uploaded under the field name
+func (form *UploadForm) File(name string) *UploadedFile {
+	if files := form.Files[name]; len(files) > 0 {
+		return files[0]
+	}
+	return nil
+}
+
+// RemoveAll deletes the temp files; MapUpload calls it once the request is done
+func (form *UploadForm) RemoveAll() {
+	for _, files := range form.Files {
+		for _, file := range files {
+			if file.path != "" {
+				os.Remove(file.path)
+			}
+		}
+	}
+}
+
+func typeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(mediaType, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUpload streams the multipart body part by part, spooling large files to disk
+func parseUpload(reader *multipart.Reader, options UploadOptions) (*UploadForm, error) {
+	threshold := options.MemoryThreshold
+	if threshold <= 0 {
+		threshold = 1 << 20
+	}
+	form := &UploadForm{Values: url.Values{}, Files: map[string][]*UploadedFile{}}
+	var total int64
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		remaining := int64(-1)
+		if options.MaxTotalSize > 0 {
+			remaining = options.MaxTotalSize - total
+		}
+		if part.FileName() == "" {
+			value, err := readLimited(part, remaining, ErrUploadTooLarge)
+			part.Close()
+			if err != nil {
+				form.RemoveAll()
+				return nil, err
+			}
+			total += int64(len(value))
+			form.Values.Add(name, string(value))
+			continue
+		}
+		file, err := spoolFile(part, options, threshold, remaining)
+		part.Close()
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		total += file.Size
+		form.Files[name] = append(form.Files[name], file)
+	}
+}
+
+func readLimited(r io.Reader, limit int64, tooLarge error) ([]byte, error) {
+	if limit < 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, tooLarge
+	}
+	return data, nil
+}
+
+func spoolFile(part *multipart.Part, options UploadOptions, threshold, remaining int64) (*UploadedFile, error) {
+	file := &UploadedFile{
+		FieldName: part.FormName(),
+		Filename:  part.FileName(),
+		Header:    part.Header,
+	}
+	limit, tooLarge := remaining, ErrUploadTooLarge
+	if options.MaxFileSize > 0 && (limit < 0 || options.MaxFileSize < limit) {
+		limit, tooLarge = options.MaxFileSize, ErrFileTooLarge
+	}
+	var reader io.Reader = part
+	if limit >= 0 {
+		reader = io.LimitReader(part, limit+1)
+	}
+
+	// Sniff the type from the first bytes before accepting anything else
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+	file.ContentType = http.DetectContentType(head)
+	if !typeAllowed(file.ContentType, options.AllowedTypes) {
+		return nil, ErrFileTypeNotAllowed
+	}
+
+	var buf bytes.Buffer
+	buf.Write(head)
+	copied, err := io.CopyN(&buf, reader, threshold-int64(n)+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	file.Size = int64(n) + copied
+	if file.Size <= threshold {
+		if limit >= 0 && file.Size > limit {
+			return nil, tooLarge
+		}
+		file.data = buf.Bytes()
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp(options.TempDir, "joker-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	file.path = tmp.Name()
+	_, err = tmp.Write(buf.Bytes())
+	var rest int64
+	if err == nil {
+		rest, err = io.Copy(tmp, reader)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.path)
+		return nil, err
+	}
+	file.Size += rest
+	if limit >= 0 && file.Size > limit {
+		os.Remove(file.path)
+		return nil, tooLarge
+	}
+	return file, nil
+}
+
+// uploadErrorStatus maps an upload error to the status sent to the client
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	}
+	return bodyErrorStatus(err)
+}
+
+func (jokerEngine *JokerEngine) serveUpload(ctx *JokerContex, options UploadOptions, handle func(request *http.Request, form *UploadForm, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) {
+	if ctx.Request.Method != http.MethodPost && ctx.Request.Method != http.MethodPut {
+		ctx.HandleError(http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	form, err := jokerEngine.parseUploadRequest(ctx, options)
+	if err != nil {
+		ctx.HandleError(uploadErrorStatus(err), err)
+		return
+	}
+	params := ctx.Request.URL.Query()
+	status, response := handle(ctx.Request, form, params, func(key, value string) {
+		ctx.ResponseWriter.Header().Set(key, value)
+	})
+	jokerEngine.writeResponse(ctx, status, response)
+}
+
+func (jokerEngine *JokerEngine) parseUploadRequest(ctx *JokerContex, options UploadOptions) (*UploadForm, error) {
+	mediaType, params, err := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, http.ErrNotMultipart
+	}
+	if options.MaxTotalSize == 0 {
+		options.MaxTotalSize = jokerEngine.bodyLimit()
+	}
+	body, err := jokerEngine.requestBody(ctx.ResponseWriter, ctx.Request)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	form, err := parseUpload(multipart.NewReader(body, params["boundary"]), options)
+	if err != nil {
+		return nil, err
+	}
+	ctx.cleanups = append(ctx.cleanups, form.RemoveAll)
+	return form, nil
+}
+
+// MapUpload registers a multipart upload route that streams parts and enforces options
+func (jokerEngine *JokerEngine) MapUpload(pattern string, options UploadOptions, handle func(request *http.Request, form *UploadForm, params url.Values, setHeaders func(key, value string)) (status int, response interface{})) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "POST, PUT")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			jokerEngine.serveUpload(ctx, options, handle)
+		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
+	})
+	return route
+}
+
+// SetMultipartMemory sets how much of a form ctx.MultipartForm keeps in memory before using temp files
+func (jokerEngine *JokerEngine) SetMultipartMemory(size int64) {
+	jokerEngine.multipartMemory = size
+}
+
+// MultipartForm parses the request as multipart/form-data; temp files are removed after the request
+func (ctx *JokerContex) MultipartForm() (*multipart.Form, error) {
+	if ctx.Request.MultipartForm != nil {
+		return ctx.Request.MultipartForm, nil
+	}
+	body, err := ctx.engine.requestBody(ctx.ResponseWriter, ctx.Request)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Request.Body = body
+	memory := ctx.engine.multipartMemory
+	if memory <= 0 {
+		memory = defaultMultipartMemory
+	}
+	err = ctx.Request.ParseMultipartForm(memory)
+	if ctx.Request.MultipartForm != nil {
+		ctx.cleanups = append(ctx.cleanups, func() { ctx.Request.MultipartForm.RemoveAll() })
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Request.MultipartForm, nil
+}
+
+// FormFile returns the first file uploaded under the field name
+func (ctx *JokerContex) FormFile(name string) (multipart.File, *multipart.FileHeader, error) {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		return nil, nil, err
+	}
+	if files := form.File[name]; len(files) > 0 {
+		file, err := files[0].Open()
+		return file, files[0], err
+	}
+	return nil, nil, http.ErrMissingFile
+}
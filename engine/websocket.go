@@ -0,0 +1,610 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocket message types, equal to the RFC 6455 opcodes
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// WebSocket close codes
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrWebSocketClosed = errors.New("websocket connection closed")
+
+// CloseError is returned by ReadMessage once the peer closed the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+type WebSocketOptions struct {
+	// Subprotocols lists the supported subprotocols in order of preference
+	Subprotocols []string
+	// CheckOrigin defaults to accepting requests without Origin or from the same host
+	CheckOrigin func(r *http.Request) bool
+	// EnableCompression negotiates permessage-deflate when the client offers it
+	EnableCompression bool
+	// ReadLimit is the maximum message size in bytes, 32 MiB when zero
+	ReadLimit int64
+	// PingInterval sends pings and drops connections that miss two of them
+	PingInterval time.Duration
+	// WriteTimeout bounds every write, 10 seconds when zero
+	WriteTimeout time.Duration
+	// FragmentSize splits outgoing messages into frames of at most this size
+	FragmentSize int
+}
+
+func (jokerEngine *JokerEngine) SetWebSocketOptions(options WebSocketOptions) {
+	jokerEngine.websocketOptions = options
+}
+
+type WebSocketConn struct {
+	Request     *http.Request
+	conn        net.Conn
+	reader      *bufio.Reader
+	subprotocol string
+	compress    bool
+	readLimit   int64
+	options     WebSocketOptions
+	codec       JSONCodec
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *WebSocketConn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// Done is closed once the connection is closed
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+func isUpgradeRequest(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		headerContainsToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := r.Host
+	if ctx := GetContext(r); ctx != nil {
+		host = ctx.Host()
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// deflateOffered reports whether the client offered permessage-deflate parameters we can honor
+func deflateOffered(r *http.Request) bool {
+	for _, value := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			acceptable := true
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				// compress/flate always uses a 32K window
+				if name == "server_max_window_bits" && strings.Trim(value, `"`) != "15" {
+					acceptable = false
+				}
+			}
+			if acceptable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the connection
+func (jokerEngine *JokerEngine) upgradeWebSocket(w http.ResponseWriter, r *http.Request, options WebSocketOptions) (*WebSocketConn, int, error) {
+	if r.Method != http.MethodGet {
+		return nil, http.StatusMethodNotAllowed, errors.New("websocket: method not GET")
+	}
+	if !isUpgradeRequest(r) {
+		return nil, http.StatusUpgradeRequired, errors.New("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, http.StatusUpgradeRequired, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, http.StatusBadRequest, errors.New("websocket: invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return nil, http.StatusForbidden, errors.New("websocket: origin not allowed")
+	}
+
+	subprotocol := ""
+	for _, supported := range options.Subprotocols {
+		if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", supported) {
+			subprotocol = supported
+			break
+		}
+	}
+	compress := options.EnableCompression && deflateOffered(r)
+
+	netConn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	response.WriteString("Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n")
+	if subprotocol != "" {
+		response.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		response.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	if server := jokerEngine.serverName(); server != "" {
+		response.WriteString("Server: " + server + "\r\n")
+	}
+	response.WriteString("\r\n")
+	netConn.SetDeadline(time.Time{})
+	if _, err := netConn.Write([]byte(response.String())); err != nil {
+		netConn.Close()
+		return nil, 0, err
+	}
+
+	readLimit := options.ReadLimit
+	if readLimit <= 0 {
+		readLimit = 32 << 20
+	}
+	return &WebSocketConn{
+		Request:     r,
+		conn:        netConn,
+		reader:      rw.Reader,
+		subprotocol: subprotocol,
+		compress:    compress,
+		readLimit:   readLimit,
+		options:     options,
+		closed:      make(chan struct{}),
+	}, http.StatusSwitchingProtocols, nil
+}
+
+type wsFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	payload []byte
+}
+
+func (c *WebSocketConn) readFrame(remaining int64) (wsFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return wsFrame{}, err
+	}
+	frame := wsFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: int(header[0] & 0x0f),
+	}
+	if header[0]&0x30 != 0 {
+		return frame, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if frame.rsv1 && !c.compress {
+		return frame, c.fail(CloseProtocolError, "unexpected compressed frame")
+	}
+	if header[1]&0x80 == 0 {
+		return frame, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame, err
+		}
+		if ext[0]&0x80 != 0 {
+			return frame, c.fail(CloseProtocolError, "invalid payload length")
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if frame.opcode >= CloseMessage {
+		if !frame.fin || length > 125 {
+			return frame, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	} else if length > remaining {
+		return frame, c.fail(CloseMessageTooBig, "message too big")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return frame, err
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		return frame, err
+	}
+	for i := range frame.payload {
+		frame.payload[i] ^= mask[i%4]
+	}
+	return frame, nil
+}
+
+// ReadMessage returns the next data message, answering pings and close frames on the way
+func (c *WebSocketConn) ReadMessage() (messageType int, data []byte, err error) {
+	var compressed bool
+	for {
+		frame, err := c.readFrame(c.readLimit - int64(len(data)))
+		if err != nil {
+			c.closeConn()
+			return 0, nil, err
+		}
+		switch frame.opcode {
+		case PingMessage:
+			c.writeFrame(PongMessage, frame.payload, true, false)
+			continue
+		case PongMessage:
+			c.extendReadDeadline()
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(frame.payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			messageType, compressed = frame.opcode, frame.rsv1
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if frame.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "rsv1 set on continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+		data = append(data, frame.payload...)
+		if frame.fin {
+			break
+		}
+	}
+	if compressed {
+		if data, err = c.inflate(data); err != nil {
+			return 0, nil, err
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return 0, nil, c.fail(CloseInvalidFramePayloadData, "invalid utf-8")
+	}
+	c.extendReadDeadline()
+	return messageType, data, nil
+}
+
+func (c *WebSocketConn) ReadJSON(v interface{}) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(data, v)
+}
+
+func (c *WebSocketConn) inflate(data []byte) ([]byte, error) {
+	tail := []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(tail)))
+	defer reader.Close()
+	inflated, err := io.ReadAll(io.LimitReader(reader, c.readLimit+1))
+	if err != nil {
+		return nil, c.fail(CloseInvalidFramePayloadData, "invalid compressed data")
+	}
+	if int64(len(inflated)) > c.readLimit {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+	return inflated, nil
+}
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+func deflate(data []byte) []byte {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := flateWriterPool.Get().(*flate.Writer)
+	w.Reset(buf)
+	w.Write(data)
+	w.Flush()
+	flateWriterPool.Put(w)
+	// Drop the empty stored block's 00 00 ff ff trailer, as RFC 7692 requires
+	return append([]byte(nil), bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})...)
+}
+
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	code, text := CloseNoStatusReceived, ""
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close payload")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		text = string(payload[2:])
+		if !validCloseCode(code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(text) {
+			return c.fail(CloseInvalidFramePayloadData, "invalid close reason")
+		}
+	}
+	echo := CloseNormalClosure
+	if code != CloseNoStatusReceived {
+		echo = code
+	}
+	c.writeFrame(CloseMessage, closePayload(echo, ""), true, false)
+	c.closeConn()
+	return &CloseError{Code: code, Text: text}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return append(payload, reason...)
+}
+
+// fail closes the connection after a protocol violation
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.writeFrame(CloseMessage, closePayload(code, reason), true, false)
+	c.closeConn()
+	return &CloseError{Code: code, Text: reason}
+}
+
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte, fin, rsv1 bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.closed:
+		return ErrWebSocketClosed
+	default:
+	}
+	header := make([]byte, 0, 10)
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	header = append(header, b0)
+	switch n := len(payload); {
+	case n <= 125:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	timeout := c.options.WriteTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := (&net.Buffers{header, payload}).WriteTo(c.conn); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteMessage sends a text or binary message, compressed and fragmented as configured
+func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		if messageType == PingMessage || messageType == PongMessage {
+			return c.writeFrame(messageType, data, true, false)
+		}
+		return errors.New("websocket: invalid message type")
+	}
+	compressed := c.compress && len(data) > 0
+	if compressed {
+		data = deflate(data)
+	}
+	size := c.options.FragmentSize
+	if size <= 0 || len(data) <= size {
+		return c.writeFrame(messageType, data, true, compressed)
+	}
+	opcode := messageType
+	for len(data) > 0 {
+		n := min(size, len(data))
+		if err := c.writeFrame(opcode, data[:n], n == len(data), compressed && opcode != continuationFrame); err != nil {
+			return err
+		}
+		data = data[n:]
+		opcode = continuationFrame
+	}
+	return nil
+}
+
+func (c *WebSocketConn) WriteText(text string) error {
+	return c.WriteMessage(TextMessage, []byte(text))
+}
+
+func (c *WebSocketConn) WriteJSON(v interface{}) error {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+func (c *WebSocketConn) Ping(data []byte) error {
+	return c.writeFrame(PingMessage, data, true, false)
+}
+
+// Close sends a close frame with the code and reason and closes the connection
+func (c *WebSocketConn) Close(code int, reason string) error {
+	err := c.writeFrame(CloseMessage, closePayload(code, reason), true, false)
+	c.closeConn()
+	return err
+}
+
+func (c *WebSocketConn) closeConn() {
+	c.closeOnce.Do(func() {
+		c.writeMu.Lock()
+		close(c.closed)
+		c.conn.Close()
+		c.writeMu.Unlock()
+	})
+}
+
+func (c *WebSocketConn) extendReadDeadline() {
+	if c.options.PingInterval > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.options.PingInterval))
+	}
+}
+
+// keepAlive pings the peer and closes the connection when the engine shuts down
+func (c *WebSocketConn) keepAlive(shutdown <-chan struct{}) {
+	var tick <-chan time.Time
+	if c.options.PingInterval > 0 {
+		c.extendReadDeadline()
+		ticker := time.NewTicker(c.options.PingInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := c.Ping(nil); err != nil {
+				return
+			}
+		case <-shutdown:
+			c.Close(CloseGoingAway, "server shutting down")
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// serveWebSocket upgrades the request and runs handle; middleware has already run by now
+func (jokerEngine *JokerEngine) serveWebSocket(ctx *JokerContex, pattern string, handle func(request *http.Request, conn *WebSocketConn)) {
+	defer ctx.withoutRequestTimeout()()
+	conn, status, err := jokerEngine.upgradeWebSocket(ctx.ResponseWriter, ctx.Request, jokerEngine.websocketOptions)
+	if err != nil {
+		if status == 0 {
+			// The connection was already hijacked, there is no response left to write
+			log.Println("[Error]:Handle in " + pattern + " >>> " + err.Error())
+			return
+		}
+		ctx.HandleError(status, err)
+		return
+	}
+	conn.codec = jokerEngine.JSONCodec()
+	go conn.keepAlive(jokerEngine.shutdownSignal())
+	defer conn.Close(CloseNormalClosure, "")
+	handle(conn.Request, conn)
+}
+
+func (jokerEngine *JokerEngine) MapWebSocket(pattern string, handle func(request *http.Request, conn *WebSocketConn)) *Route {
+	route := jokerEngine.addRoute(nil, pattern, "GET")
+	http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		finalHandler := func(ctx *JokerContex) {
+			jokerEngine.serveWebSocket(ctx, pattern, handle)
+		}
+		jokerEngine.newContext(w, r, finalHandler, jokerEngine.middlewares, route.chain).run()
+	})
+	return route
+}
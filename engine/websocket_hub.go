@@ -0,0 +1,123 @@
+package engine
+
+import "sync"
+
+// WebSocketHub tracks open connections and the rooms they joined
+type WebSocketHub struct {
+	mu    sync.RWMutex
+	conns map[*WebSocketConn]map[string]struct{}
+	rooms map[string]map[*WebSocketConn]struct{}
+}
+
+func NewWebSocketHub() *WebSocketHub {
+	return &WebSocketHub{
+		conns: make(map[*WebSocketConn]map[string]struct{}),
+		rooms: make(map[string]map[*WebSocketConn]struct{}),
+	}
+}
+
+// Register adds the connection and removes it again, including from its rooms, once it closes
+func (hub *WebSocketHub) Register(conn *WebSocketConn) {
+	hub.mu.Lock()
+	if _, ok := hub.conns[conn]; ok {
+		hub.mu.Unlock()
+		return
+	}
+	hub.conns[conn] = make(map[string]struct{})
+	hub.mu.Unlock()
+	go func() {
+		<-conn.Done()
+		hub.Unregister(conn)
+	}()
+}
+
+func (hub *WebSocketHub) Unregister(conn *WebSocketConn) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for room := range hub.conns[conn] {
+		hub.leave(room, conn)
+	}
+	delete(hub.conns, conn)
+}
+
+// Join registers the connection if needed and adds it to the room
+func (hub *WebSocketHub) Join(room string, conn *WebSocketConn) {
+	hub.Register(conn)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	rooms, ok := hub.conns[conn]
+	if !ok {
+		return
+	}
+	rooms[room] = struct{}{}
+	if hub.rooms[room] == nil {
+		hub.rooms[room] = make(map[*WebSocketConn]struct{})
+	}
+	hub.rooms[room][conn] = struct{}{}
+}
+
+func (hub *WebSocketHub) Leave(room string, conn *WebSocketConn) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.leave(room, conn)
+}
+
+func (hub *WebSocketHub) leave(room string, conn *WebSocketConn) {
+	delete(hub.conns[conn], room)
+	delete(hub.rooms[room], conn)
+	if len(hub.rooms[room]) == 0 {
+		delete(hub.rooms, room)
+	}
+}
+
+// Broadcast sends the message to every registered connection
+func (hub *WebSocketHub) Broadcast(messageType int, data []byte) {
+	hub.mu.RLock()
+	targets := make([]*WebSocketConn, 0, len(hub.conns))
+	for conn := range hub.conns {
+		targets = append(targets, conn)
+	}
+	hub.mu.RUnlock()
+	broadcastTo(targets, messageType, data, nil)
+}
+
+// BroadcastRoom sends the message to everyone in the room except the given connection, if any
+func (hub *WebSocketHub) BroadcastRoom(room string, messageType int, data []byte, except *WebSocketConn) {
+	hub.mu.RLock()
+	targets := make([]*WebSocketConn, 0, len(hub.rooms[room]))
+	for conn := range hub.rooms[room] {
+		targets = append(targets, conn)
+	}
+	hub.mu.RUnlock()
+	broadcastTo(targets, messageType, data, except)
+}
+
+// broadcastTo writes concurrently so one slow client does not hold up the others
+func broadcastTo(targets []*WebSocketConn, messageType int, data []byte, except *WebSocketConn) {
+	var wg sync.WaitGroup
+	for _, conn := range targets {
+		if conn == except {
+			continue
+		}
+		wg.Add(1)
+		go func(conn *WebSocketConn) {
+			defer wg.Done()
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				conn.closeConn()
+			}
+		}(conn)
+	}
+	wg.Wait()
+}
+
+func (hub *WebSocketHub) Count() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.conns)
+}
+
+func (hub *WebSocketHub) RoomCount(room string) int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.rooms[room])
+}
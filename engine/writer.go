@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// responseWriter records what was sent so middleware can inspect it after ctx.Next()
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	written     bool
+	beforeWrite []func(status int)
+	afterWrite  []func()
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		// 1xx responses are interim, the final header is still to come
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.written {
+		log.Printf("[Warning]:superfluous WriteHeader(%d), %d was already sent\n", status, w.status)
+		return
+	}
+	w.writeHeader(status)
+}
+
+func (w *responseWriter) writeHeader(status int) {
+	w.written = true
+	w.status = status
+	for _, hook := range w.beforeWrite {
+		hook(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+	for i := len(w.afterWrite) - 1; i >= 0; i-- {
+		w.afterWrite[i]()
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.written {
+		w.writeHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile path of the underlying writer for io.Copy
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !w.written {
+		w.writeHeader(http.StatusOK)
+	}
+	var n int64
+	var err error
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = readerFrom.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if !w.written {
+		w.writeHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status is the status sent to the client, or 200 while nothing has been written
+func (ctx *JokerContex) Status() int {
+	return ctx.writer.status
+}
+
+// Size is the number of body bytes written so far
+func (ctx *JokerContex) Size() int64 {
+	return ctx.writer.size
+}
+
+// Written reports whether the response status has already been sent
+func (ctx *JokerContex) Written() bool {
+	return ctx.writer.written
+}
+
+// BeforeWrite registers hook to run just before the status is sent, while headers can still be changed
+func (ctx *JokerContex) BeforeWrite(hook func(status int)) {
+	ctx.writer.beforeWrite = append(ctx.writer.beforeWrite, hook)
+}
+
+// OnHeadersWritten registers hook to run right after the status and headers are sent; hooks run in reverse order
+func (ctx *JokerContex) OnHeadersWritten(hook func()) {
+	ctx.writer.afterWrite = append(ctx.writer.afterWrite, hook)
+}
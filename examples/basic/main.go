@@ -27,7 +27,7 @@ func main() {
 	joker.Use(func(ctx *engine.JokerContex) {
 		if ctx.Request.Header.Get("Authorization") != "secret" {
 			ctx.AbortWithStatusJSON(401, map[string]string{"error": "Unauthorized"})
-			ctx.Abort()
+			return
 		}
 		ctx.Next()
 	})
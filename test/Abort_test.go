@@ -0,0 +1,106 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestAbortStopsChain(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/abort")
+	type result struct {
+		aborted bool
+		reason  error
+		errs    []error
+	}
+	results := make(chan result, 1)
+	errAudit := errors.New("audit log unavailable")
+	api.Use(func(ctx *engine.JokerContex) {
+		ctx.Next()
+		// A second Next after the abort must not reach the handler
+		ctx.Next()
+		results <- result{ctx.IsAborted(), ctx.AbortReason(), ctx.Errors()}
+	})
+	api.Use(func(ctx *engine.JokerContex) {
+		ctx.Error(errAudit)
+		ctx.Use(func(ctx *engine.JokerContex) {
+			t.Error("middleware added with Use ran after abort")
+		})
+		ctx.AbortWithStatus(http.StatusForbidden)
+		ctx.Next()
+	})
+	handled := false
+	api.MapGet("/denied", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		handled = true
+		return 200, "unreachable"
+	})
+	server := serve(t)
+
+	resp, _ := get(t, server.URL+"/abort/denied", nil)
+	if resp.StatusCode != http.StatusForbidden || handled {
+		t.Fatalf("status %d, handled %v", resp.StatusCode, handled)
+	}
+	got := <-results
+	var httpErr *engine.HTTPError
+	if !got.aborted || !errors.As(got.reason, &httpErr) || httpErr.Status != http.StatusForbidden {
+		t.Fatalf("abort state %+v", got)
+	}
+	if len(got.errs) != 1 || got.errs[0] != errAudit {
+		t.Fatalf("errors %v", got.errs)
+	}
+}
+
+func TestUseRunsBeforeHandler(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/use")
+	api.Use(func(ctx *engine.JokerContex) {
+		ctx.Use(func(ctx *engine.JokerContex) {
+			ctx.Set("added", true)
+			ctx.Next()
+		})
+		ctx.Next()
+	})
+	api.MapGet("/late", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		added, _ := engine.RequestValue[bool](request, "added")
+		return 200, added
+	})
+	server := serve(t)
+
+	if _, body := get(t, server.URL+"/use/late", nil); body != "true" {
+		t.Fatalf("body %q", body)
+	}
+}
+
+func TestUseAfterHandlerIsDropped(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/use-after")
+	late := false
+	api.Use(func(ctx *engine.JokerContex) {
+		ctx.Next()
+		ctx.Use(func(ctx *engine.JokerContex) {
+			late = true
+			ctx.Next()
+		})
+		ctx.Next()
+	})
+	runs := 0
+	api.MapGet("/once", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		runs++
+		return 200, runs
+	})
+	server := serve(t)
+
+	if _, body := get(t, server.URL+"/use-after/once", nil); body != "1" || runs != 1 || late {
+		t.Fatalf("body %q, handler ran %d times, late middleware ran %v", body, runs, late)
+	}
+}
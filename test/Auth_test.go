@@ -0,0 +1,98 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestBasicAndAPIKeyAuth(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	aliceHash, err := engine.HashPassword("wonderland")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := engine.HashPassword("wonderland"); other == aliceHash {
+		t.Fatal("password hashes are not salted")
+	}
+	if engine.VerifyPassword("wonderland", engine.HashSecret("wonderland")) {
+		t.Fatal("unsalted hash accepted as a password hash")
+	}
+	whoami := func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		user, _ := engine.RequestValue[string](request, "user")
+		return 200, user
+	}
+	basic := router.Group("/auth-basic")
+	basic.Use(engine.BasicAuth(engine.BasicAuthOptions{
+		Realm:    "admin",
+		Validate: engine.BasicAuthAccounts(map[string]string{"alice": aliceHash}),
+	}))
+	basic.MapGet("/me", whoami)
+	keys := router.Group("/auth-key")
+	keys.Use(engine.APIKey(engine.APIKeyOptions{
+		Header:   "X-API-Key",
+		Query:    "api_key",
+		Cookie:   "api_key",
+		Validate: engine.HashedAPIKeys(map[string]interface{}{engine.HashSecret("k-123"): "service-a"}),
+	}))
+	keys.MapGet("/me", whoami)
+	server := serve(t)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/auth-basic/me", nil)
+	req.SetBasicAuth("alice", "wonderland")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("basic auth: %d", resp.StatusCode)
+	}
+	req.SetBasicAuth("alice", "wrong")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 || resp.Header.Get("WWW-Authenticate") != `Basic realm="admin", charset="UTF-8"` {
+		t.Fatalf("wrong password: %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	if _, body := get(t, server.URL+"/auth-key/me?api_key=k-123", nil); body != `"service-a"` {
+		t.Fatalf("query key: %s", body)
+	}
+	if _, body := get(t, server.URL+"/auth-key/me", map[string]string{"Cookie": "api_key=k-123"}); body != `"service-a"` {
+		t.Fatalf("cookie key: %s", body)
+	}
+	if resp, _ := get(t, server.URL+"/auth-key/me", map[string]string{"X-API-Key": "k-999"}); resp.StatusCode != 401 {
+		t.Fatalf("unknown key: %d", resp.StatusCode)
+	}
+}
+
+func TestBasicAuthAccountsCache(t *testing.T) {
+	hash, err := engine.HashPassword("wonderland")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := engine.BasicAuthAccounts(map[string]string{"alice": hash})
+	if _, ok := validate("alice", "wonderland"); !ok {
+		t.Fatal("correct password rejected")
+	}
+	// An accepted password is not hashed again, a different one still is and fails
+	start := time.Now()
+	if _, ok := validate("alice", "wonderland"); !ok || time.Since(start) > 20*time.Millisecond {
+		t.Fatalf("repeat check: %v after %v", ok, time.Since(start))
+	}
+	for _, password := range []string{"wonderland ", "Wonderland", ""} {
+		if _, ok := validate("alice", password); ok {
+			t.Fatalf("password %q accepted after a cached success", password)
+		}
+	}
+	if _, ok := validate("bob", "wonderland"); ok {
+		t.Fatal("unknown user accepted with a cached password")
+	}
+}
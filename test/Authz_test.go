@@ -0,0 +1,106 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+type testUser struct {
+	name        string
+	roles       []string
+	permissions []string
+}
+
+func (u testUser) Roles() []string       { return u.roles }
+func (u testUser) Permissions() []string { return u.permissions }
+
+func TestAuthorization(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	users := map[string]testUser{
+		"ann": {name: "ann", roles: []string{"staff"}, permissions: []string{"docs:write"}},
+		"bob": {name: "bob", roles: []string{"staff"}},
+		"eve": {name: "eve"},
+	}
+	docs := router.Group("/authz")
+	docs.Use(engine.BasicAuth(engine.BasicAuthOptions{Validate: func(username, password string) (interface{}, bool) {
+		user, ok := users[username]
+		return user, ok
+	}}))
+	docs.Require("staff")
+	ok := func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, "ok"
+	}
+	docs.MapGet("/docs", ok)
+	docs.MapPost("/docs/new", func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, "ok"
+	}).RequirePermission("docs:write")
+	docs.MapGet("/owned/{owner}", ok).RequirePolicy("owner", func(ctx *engine.JokerContex, principal engine.Principal) bool {
+		return principal.(testUser).name == ctx.Request.PathValue("owner")
+	})
+	// Sibling groups must not share middleware storage, or users.Use would replace admin's check
+	api := router.Group("/authz-api")
+	noop := func(ctx *engine.JokerContex) { ctx.Next() }
+	api.Use(noop)
+	api.Use(noop)
+	api.Use(noop)
+	admin := api.Group("/admin")
+	members := api.Group("/users")
+	admin.Require("admin")
+	members.Use(noop)
+	admin.MapGet("/secret", ok)
+	members.MapGet("/list", ok)
+	server := serve(t)
+
+	status := func(method, path, user string) int {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, "")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, c := range []struct {
+		method, path, user string
+		want               int
+	}{
+		{"GET", "/authz/docs", "bob", 200},
+		{"GET", "/authz/docs", "eve", 403},
+		{"GET", "/authz/docs", "", 401},
+		{"POST", "/authz/docs/new", "ann", 200},
+		{"POST", "/authz/docs/new", "bob", 403},
+		{"GET", "/authz/owned/bob", "bob", 200},
+		{"GET", "/authz/owned/bob", "ann", 403},
+		{"GET", "/authz-api/admin/secret", "", 401},
+		{"GET", "/authz-api/users/list", "", 200},
+	} {
+		if got := status(c.method, c.path, c.user); got != c.want {
+			t.Fatalf("%s %s as %q: %d, want %d", c.method, c.path, c.user, got, c.want)
+		}
+	}
+
+	want := map[string]engine.RouteInfo{
+		"/authz/docs/new":      {Pattern: "/authz/docs/new", Method: "POST", Roles: []string{"staff"}, Permissions: []string{"docs:write"}},
+		"/authz/owned/{owner}": {Pattern: "/authz/owned/{owner}", Method: "GET", Roles: []string{"staff"}, Policies: []string{"owner"}},
+	}
+	for _, info := range joker.Routes.Introspect() {
+		if expected, found := want[info.Pattern]; found && info.Method == expected.Method {
+			if !reflect.DeepEqual(info, expected) {
+				t.Fatalf("introspect %+v, want %+v", info, expected)
+			}
+			delete(want, info.Pattern)
+		}
+	}
+	if len(want) != 0 {
+		t.Fatalf("routes missing from Introspect: %v", want)
+	}
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestPostBody(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetMaxBodySize(1024)
+	joker.MapPost("/body/echo", func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, len(body)
+	})
+	joker.MapPostStream("/body/stream", func(request *http.Request, body io.Reader, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		n, err := io.Copy(io.Discard, body)
+		if err != nil {
+			return 413, nil
+		}
+		return 200, n
+	})
+	server := serve(t)
+
+	post := func(path string, body io.Reader, encoding string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, body)
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	// Chunked request without Content-Length
+	if status, body := post("/body/echo", io.MultiReader(strings.NewReader("hello "), strings.NewReader("world")), ""); status != 200 || body != "11" {
+		t.Fatalf("chunked: %d %s", status, body)
+	}
+	if status, _ := post("/body/echo", strings.NewReader(strings.Repeat("x", 2048)), ""); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized: %d", status)
+	}
+
+	var gz bytes.Buffer
+	writer := gzip.NewWriter(&gz)
+	writer.Write([]byte(strings.Repeat("a", 600)))
+	writer.Close()
+	if status, body := post("/body/echo", bytes.NewReader(gz.Bytes()), "gzip"); status != 200 || body != "600" {
+		t.Fatalf("gzip: %d %s", status, body)
+	}
+
+	// The limit applies to the decoded size
+	gz.Reset()
+	writer = gzip.NewWriter(&gz)
+	writer.Write([]byte(strings.Repeat("a", 4096)))
+	writer.Close()
+	if status, _ := post("/body/echo", bytes.NewReader(gz.Bytes()), "gzip"); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("gzip bomb: %d", status)
+	}
+	if status, _ := post("/body/echo", strings.NewReader("x"), "br"); status != http.StatusUnsupportedMediaType {
+		t.Fatalf("unknown encoding: %d", status)
+	}
+	if status, body := post("/body/stream", strings.NewReader(strings.Repeat("s", 1000)), ""); status != 200 || body != "1000" {
+		t.Fatalf("stream: %d %s", status, body)
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestCORS(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/cors")
+	api.Use(engine.CORS(engine.CORSOptions{
+		AllowOrigins:       []string{"https://app.example.com", "https://*.example.org"},
+		AllowOriginRegexps: []string{`https://preview-\d+\.example\.net`},
+		AllowMethods:       []string{"GET", "PUT"},
+		AllowHeaders:       []string{"Content-Type", "X-Token"},
+		ExposeHeaders:      []string{"X-Total"},
+		AllowCredentials:   true,
+		MaxAge:             10 * time.Minute,
+	}))
+	api.MapGet("/items", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, "items"
+	})
+	server := serve(t)
+
+	preflight := func(origin, method, headers string) *http.Response {
+		req, _ := http.NewRequest(http.MethodOptions, server.URL+"/cors/items", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := preflight("https://api.example.org", "PUT", "x-token")
+	if resp.StatusCode != http.StatusNoContent ||
+		resp.Header.Get("Access-Control-Allow-Origin") != "https://api.example.org" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+		resp.Header.Get("Access-Control-Allow-Credentials") != "true" ||
+		resp.Header.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("preflight %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp = preflight("https://app.example.com", "DELETE", ""); resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("disallowed method was allowed")
+	}
+	if resp = preflight("https://evil.com", "GET", ""); resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("disallowed origin was allowed")
+	}
+
+	resp, body := get(t, server.URL+"/cors/items", map[string]string{"Origin": "https://preview-42.example.net"})
+	if body != `"items"` || resp.Header.Get("Access-Control-Allow-Origin") != "https://preview-42.example.net" ||
+		resp.Header.Get("Access-Control-Expose-Headers") != "X-Total" || resp.Header.Get("Vary") != "Origin" {
+		t.Fatalf("actual request headers %v", resp.Header)
+	}
+}
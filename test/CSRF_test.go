@@ -0,0 +1,214 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestCSRF(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "form.html"), []byte(`<script>const token = "{{csrfToken}}"</script><form method="post">{{csrfField}}</form>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetCookieKeyring(engine.NewKeyring([]byte("csrf secret")))
+	if err := joker.LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	router := joker.NewRouter()
+	modes := map[string]engine.CSRFMode{"double": engine.CSRFDoubleSubmit, "sync": engine.CSRFSynchronizer}
+	for name, mode := range modes {
+		group := router.Group("/csrf-" + name)
+		if mode == engine.CSRFSynchronizer {
+			group.Use(engine.Sessions(engine.SessionOptions{CookieName: "sid-csrf"}))
+		}
+		group.Use(engine.CSRF(engine.CSRFOptions{Mode: mode, Exempt: []string{"/csrf-" + name + "/hooks/*"}}))
+		group.MapGet("/form", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			return engine.GetContext(request).HTML(200, "form.html", nil)
+		})
+		group.MapPost("/submit", func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			form, _ := url.ParseQuery(string(body))
+			return 200, form.Get("name")
+		})
+		group.MapPost("/hooks/push", func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			return 200, "hook"
+		})
+	}
+	server := serve(t)
+	field := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+	for name := range modes {
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		base := server.URL + "/csrf-" + name
+		post := func(path string, form url.Values, headers map[string]string) int {
+			req, _ := http.NewRequest(http.MethodPost, base+path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		if status := post("/submit", url.Values{"name": {"joker"}}, nil); status != 403 {
+			t.Fatalf("%s: post without token got %d", name, status)
+		}
+		resp, err := client.Get(base + "/form")
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		match := field.FindSubmatch(page)
+		if match == nil {
+			t.Fatalf("%s: form has no token field: %s", name, page)
+		}
+		token := string(match[1])
+		script := regexp.MustCompile(`const token = "([^"]+)"`).FindSubmatch(page)
+		if script == nil || string(script[1]) == token {
+			t.Fatalf("%s: script token missing or not masked separately: %s", name, page)
+		}
+		if status := post("/submit", nil, map[string]string{"X-CSRF-Token": string(script[1])}); status != 200 {
+			t.Fatalf("%s: post with script token got %d", name, status)
+		}
+
+		if status := post("/submit", url.Values{"name": {"joker"}, "csrf_token": {token}}, nil); status != 200 {
+			t.Fatalf("%s: post with form token got %d", name, status)
+		}
+		if status := post("/submit", url.Values{"name": {"joker"}}, map[string]string{"X-CSRF-Token": token}); status != 200 {
+			t.Fatalf("%s: post with header token got %d", name, status)
+		}
+		if status := post("/submit", url.Values{"csrf_token": {token[1:]}}, nil); status != 403 {
+			t.Fatalf("%s: post with broken token got %d", name, status)
+		}
+		if status := post("/submit", url.Values{"csrf_token": {token}}, map[string]string{"Origin": "https://evil.example"}); status != 403 {
+			t.Fatalf("%s: cross-origin post got %d", name, status)
+		}
+		if status := post("/submit", url.Values{"csrf_token": {token}}, map[string]string{"Referer": server.URL + "/csrf-" + name + "/form"}); status != 200 {
+			t.Fatalf("%s: same-origin referer got %d", name, status)
+		}
+		if status := post("/hooks/push", nil, nil); status != 200 {
+			t.Fatalf("%s: exempt path got %d", name, status)
+		}
+	}
+}
+
+func TestCSRFMultipart(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	group := joker.NewRouter().Group("/csrf-multipart")
+	group.Use(engine.CSRF(engine.CSRFOptions{}))
+	group.MapGet("/token", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, engine.GetContext(request).CSRFToken()
+	})
+	group.MapUpload("/upload", engine.UploadOptions{}, func(request *http.Request, form *engine.UploadForm, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		file := form.File("file")
+		if file == nil {
+			return 400, "no file"
+		}
+		return 200, form.Values.Get("name") + " " + strconv.FormatInt(file.Size, 10)
+	})
+	group.MapPost("/raw", func(request *http.Request, body []byte, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, strings.Count(string(body), "payload")
+	})
+	server := serve(t)
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(server.URL + "/csrf-multipart/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quoted, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	token := strings.Trim(string(quoted), `"`)
+
+	post := func(path string, tokenFirst bool) (int, string) {
+		var buf bytes.Buffer
+		form := multipart.NewWriter(&buf)
+		if tokenFirst {
+			form.WriteField("csrf_token", token)
+		}
+		form.WriteField("name", "joker")
+		file, _ := form.CreateFormFile("file", "a.txt")
+		file.Write([]byte(strings.Repeat("payload ", 1000)))
+		if !tokenFirst {
+			form.WriteField("csrf_token", token)
+		}
+		form.Close()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/csrf-multipart"+path, &buf)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	for _, tokenFirst := range []bool{true, false} {
+		if status, body := post("/upload", tokenFirst); status != 200 || body != `"joker 8000"` {
+			t.Fatalf("upload with token first=%v: %d %s", tokenFirst, status, body)
+		}
+		if status, body := post("/raw", tokenFirst); status != 200 || body != "1000" {
+			t.Fatalf("raw post with token first=%v: %d %s", tokenFirst, status, body)
+		}
+	}
+}
+
+func TestCSRFFieldContext(t *testing.T) {
+	dir := t.TempDir()
+	pages := map[string]string{
+		"text.html":      `<form method="post"><p>{{csrfField}}</p></form>`,
+		"attribute.html": `<div title="{{csrfField}}"></div>`,
+		"script.html":    `<script>const field = {{csrfField}}</script>`,
+		"url.html":       `<a href="/next?{{csrfField}}">next</a>`,
+	}
+	for name, page := range pages {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(page), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	joker := engine.NewEngine()
+	joker.Init()
+	if err := joker.LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	group := joker.NewRouter().Group("/csrf-field")
+	group.Use(engine.CSRF(engine.CSRFOptions{}))
+	group.MapGet("/page", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return engine.GetContext(request).HTML(200, params.Get("name"), nil)
+	})
+	server := serve(t)
+
+	for name := range pages {
+		resp, body := get(t, server.URL+"/csrf-field/page?name="+name, nil)
+		if name == "text.html" {
+			if resp.StatusCode != 200 || !strings.Contains(body, `<p><input type="hidden" name="csrf_token" value="`) {
+				t.Fatalf("field in text: %d %s", resp.StatusCode, body)
+			}
+			continue
+		}
+		// Markup inside an attribute, script or URL would break out of it, so rendering fails instead
+		if resp.StatusCode != 500 || strings.Contains(body, "csrf_token") || strings.Contains(body, "jokertpl") {
+			t.Fatalf("field in %s: %d %s", name, resp.StatusCode, body)
+		}
+	}
+}
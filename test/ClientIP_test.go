@@ -0,0 +1,53 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestClientIP(t *testing.T) {
+	remote := func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		ctx := engine.GetContext(request)
+		return 200, ctx.ClientIP() + " " + ctx.Scheme() + " " + ctx.Host()
+	}
+	trusting := engine.NewEngine()
+	trusting.Init()
+	if err := trusting.SetTrustedProxies("127.0.0.1", "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if err := trusting.SetTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("invalid CIDR accepted")
+	}
+	trusting.MapGet("/client-ip/trusted", remote)
+	direct := engine.NewEngine()
+	direct.Init()
+	direct.MapGet("/client-ip/direct", remote)
+	server := serve(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cases := []struct {
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{"/client-ip/trusted", nil, "127.0.0.1 http " + host},
+		{"/client-ip/trusted", map[string]string{"X-Forwarded-For": "203.0.113.9, 10.1.2.3", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "shop.example"}, "203.0.113.9 https shop.example"},
+		// Whatever the client put in front of the first untrusted hop is ignored
+		{"/client-ip/trusted", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.9"}, "203.0.113.9 http " + host},
+		{"/client-ip/trusted", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https;host=shop.example, for=10.0.0.5`}, "2001:db8::1 https shop.example"},
+		{"/client-ip/trusted", map[string]string{"Forwarded": `for=unknown`}, "127.0.0.1 http " + host},
+		{"/client-ip/trusted", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7 http " + host},
+		{"/client-ip/trusted", map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Host": "evil/host"}, "203.0.113.9 http " + host},
+		{"/client-ip/direct", map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"}, "127.0.0.1 http " + host},
+	}
+	for _, c := range cases {
+		_, body := get(t, server.URL+c.path, c.headers)
+		if body != `"`+c.want+`"` {
+			t.Fatalf("%v: got %s, want %q", c.headers, body, c.want)
+		}
+	}
+}
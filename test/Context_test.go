@@ -0,0 +1,49 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+type contextUser struct {
+	Name string
+}
+
+func userName(request *http.Request) string {
+	user, ok := engine.RequestValue[contextUser](request, "user")
+	if !ok {
+		return ""
+	}
+	return user.Name
+}
+
+func TestContextValues(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	group := router.Group("/values")
+	group.Use(func(ctx *engine.JokerContex) {
+		ctx.Set("user", contextUser{Name: ctx.Request.Header.Get("X-User")})
+		ctx.Next()
+	})
+	group.MapGet("/me", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		ctx := engine.GetContext(request)
+		user, ok := engine.Value[contextUser](ctx, "user")
+		if !ok {
+			return 500, nil
+		}
+		if _, ok := engine.Value[string](ctx, "user"); ok {
+			return 500, "wrong type accepted"
+		}
+		return 200, user.Name + "/" + userName(request)
+	})
+	server := serve(t)
+
+	_, body := get(t, server.URL+"/values/me", map[string]string{"X-User": "joker"})
+	if body != `"joker/joker"` {
+		t.Fatalf("body %s", body)
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestCookieHelpers(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetCookieKeyring(engine.NewKeyring([]byte("current secret"), []byte("old secret")))
+	router := joker.NewRouter()
+	api := router.Group("/cookies")
+	api.MapGet("/set", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		ctx := engine.GetContext(request)
+		if err := ctx.SetCookie("theme", "dark", nil); err != nil {
+			return 500, err
+		}
+		if err := ctx.SetSignedCookie("uid", "42", nil); err != nil {
+			return 500, err
+		}
+		if err := ctx.SetEncryptedCookie("secret", "hush", &engine.CookieOptions{SameSite: http.SameSiteStrictMode}); err != nil {
+			return 500, err
+		}
+		if err := ctx.SetCookie("huge", strings.Repeat("x", 5000), nil); !errors.Is(err, engine.ErrCookieTooLarge) {
+			return 500, errors.New("oversized cookie was accepted")
+		}
+		return 200, nil
+	})
+	api.MapGet("/read", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		ctx := engine.GetContext(request)
+		theme, _ := ctx.Cookie("theme")
+		uid, uidErr := ctx.SignedCookie("uid")
+		secret, secretErr := ctx.EncryptedCookie("secret")
+		if uidErr != nil || secretErr != nil {
+			return 400, engine.NewHTTPError(400, "bad_cookie", "")
+		}
+		return 200, theme + "," + uid + "," + secret
+	})
+	server := serve(t)
+
+	resp, _ := get(t, server.URL+"/cookies/set", nil)
+	cookies := resp.Cookies()
+	if resp.StatusCode != 200 || len(cookies) != 3 {
+		t.Fatalf("status %d, cookies %v", resp.StatusCode, resp.Header["Set-Cookie"])
+	}
+	for _, cookie := range cookies {
+		if !cookie.HttpOnly || cookie.Path != "/" || cookie.SameSite == http.SameSiteDefaultMode {
+			t.Fatalf("cookie %s lacks secure defaults: %v", cookie.Name, cookie)
+		}
+	}
+
+	header := ""
+	for _, cookie := range cookies {
+		header += cookie.Name + "=" + cookie.Value + "; "
+	}
+	if _, body := get(t, server.URL+"/cookies/read", map[string]string{"Cookie": header}); body != `"dark,42,hush"` {
+		t.Fatalf("read back %s", body)
+	}
+	tampered := strings.Replace(header, "uid=", "uid=x", 1)
+	if resp, _ := get(t, server.URL+"/cookies/read", map[string]string{"Cookie": tampered}); resp.StatusCode != 400 {
+		t.Fatalf("tampered signed cookie: %d", resp.StatusCode)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old := engine.NewKeyring([]byte("old secret"))
+	rotated := engine.NewKeyring([]byte("new secret"), []byte("old secret"))
+	retired := engine.NewKeyring([]byte("new secret"))
+
+	signed := old.Sign("uid", []byte("42"))
+	if value, ok := rotated.Verify("uid", signed); !ok || string(value) != "42" {
+		t.Fatal("rotated keyring rejected a cookie signed with the old key")
+	}
+	if _, ok := rotated.Verify("other", signed); ok {
+		t.Fatal("signature accepted under another cookie name")
+	}
+	sealed, _ := old.Encrypt("secret", []byte("hush"))
+	if value, ok := rotated.Decrypt("secret", sealed); !ok || string(value) != "hush" {
+		t.Fatal("rotated keyring could not decrypt with the old key")
+	}
+	if _, ok := retired.Decrypt("secret", sealed); ok {
+		t.Fatal("retired key still decrypts")
+	}
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestErrorHandling(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/errors")
+	api.MapGet("/missing", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 404, engine.NewHTTPError(404, "user_not_found", "no such user").WithDetails(map[string]string{"id": "7"})
+	})
+	api.MapGet("/internal", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, errors.New("database password rejected")
+	})
+	custom := router.Group("/errors-custom")
+	custom.SetErrorHandler(func(ctx *engine.JokerContex, err *engine.HTTPError) {
+		ctx.ResponseWriter.Header().Set("Content-Type", "text/plain")
+		ctx.ResponseWriter.WriteHeader(err.Status)
+		ctx.ResponseWriter.Write([]byte("custom " + err.Code))
+	})
+	custom.Use(func(ctx *engine.JokerContex) {
+		ctx.AbortWithError(403, engine.NewHTTPError(403, "forbidden", ""))
+	})
+	custom.MapGet("/denied", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, "unreachable"
+	})
+	server := serve(t)
+
+	resp, body := get(t, server.URL+"/errors/missing", nil)
+	if resp.StatusCode != 404 || resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var problem engine.Problem
+	if err := json.Unmarshal([]byte(body), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Type != "about:blank" || problem.Title != "Not Found" || problem.Code != "user_not_found" ||
+		problem.Detail != "no such user" || problem.Instance != "/errors/missing" {
+		t.Fatalf("problem %+v", problem)
+	}
+
+	resp, body = get(t, server.URL+"/errors/internal", nil)
+	if resp.StatusCode != 500 || strings.Contains(body, "password") {
+		t.Fatalf("internal error leaked: %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = get(t, server.URL+"/errors-custom/denied", nil)
+	if resp.StatusCode != 403 || body != "custom forbidden" {
+		t.Fatalf("custom handler: %d %s", resp.StatusCode, body)
+	}
+
+	resp, _ = get(t, server.URL+"/errors/missing", map[string]string{"Accept": "text/nothing"})
+	if resp.StatusCode != 404 {
+		t.Fatalf("error response should not be negotiated: %d", resp.StatusCode)
+	}
+}
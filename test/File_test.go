@@ -0,0 +1,59 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestFileHelpers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	os.WriteFile(path, []byte("a,b\n1,2\n"), 0o644)
+	modTime := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.Use(func(ctx *engine.JokerContex) {
+		ctx.ResponseWriter.Header().Set("X-Middleware", "ran")
+		ctx.Next()
+	})
+	joker.MapGet("/file/report", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return engine.GetContext(request).Attachment("报告.csv").File(path)
+	})
+	joker.MapGet("/file/stream", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return engine.GetContext(request).Stream(strings.NewReader("0123456789"), modTime)
+	})
+	joker.MapGet("/file/missing", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return engine.GetContext(request).File(path + ".missing")
+	})
+	server := serve(t)
+
+	resp, body := get(t, server.URL+"/file/report", nil)
+	if body != "a,b\n1,2\n" || resp.Header.Get("X-Middleware") != "ran" {
+		t.Fatalf("file: %q %v", body, resp.Header)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=__.csv; filename*=UTF-8''%E6%8A%A5%E5%91%8A.csv` {
+		t.Fatalf("content disposition %q", cd)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/csv") {
+		t.Fatalf("content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	resp, body = get(t, server.URL+"/file/stream", map[string]string{"Range": "bytes=2-4"})
+	if resp.StatusCode != http.StatusPartialContent || body != "234" {
+		t.Fatalf("range: %d %q", resp.StatusCode, body)
+	}
+	resp, _ = get(t, server.URL+"/file/stream", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("if-modified-since: %d", resp.StatusCode)
+	}
+	if resp, _ = get(t, server.URL+"/file/missing", nil); resp.StatusCode != 404 || resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("missing: %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
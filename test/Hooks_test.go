@@ -0,0 +1,52 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestFinishHooks(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/hooks")
+	calls := make(chan string, 1)
+	api.Use(func(ctx *engine.JokerContex) {
+		var order []string
+		ctx.OnFinish(func() {
+			order = append(order, "finish-outer")
+			calls <- strings.Join(order, ",")
+		})
+		ctx.OnFinish(func() { order = append(order, "finish-inner") })
+		ctx.OnHeadersWritten(func() { order = append(order, "headers-1") })
+		ctx.OnHeadersWritten(func() { order = append(order, "headers-2") })
+		ctx.Next()
+	})
+	api.Use(engine.Recovery())
+	api.Use(func(ctx *engine.JokerContex) {
+		if ctx.Request.URL.Query().Has("deny") {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		ctx.Next()
+	})
+	api.MapGet("/run", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		if params.Has("panic") {
+			panic("boom")
+		}
+		return 200, "ok"
+	})
+	server := serve(t)
+
+	want := "headers-2,headers-1,finish-inner,finish-outer"
+	for _, query := range []string{"", "?deny", "?panic"} {
+		get(t, server.URL+"/hooks/run"+query, nil)
+		if got := <-calls; got != want {
+			t.Fatalf("%q: hooks ran as %s", query, got)
+		}
+	}
+}
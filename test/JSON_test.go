@@ -0,0 +1,46 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+type upperCodec struct {
+	engine.StdJSONCodec
+	used *bool
+}
+
+func (c upperCodec) NewEncoder(w io.Writer) engine.JSONEncoder {
+	*c.used = true
+	return json.NewEncoder(w)
+}
+
+func TestJSONOptions(t *testing.T) {
+	used := false
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetJSONCodec(upperCodec{used: &used})
+	joker.SetJSONEscapeHTML(false)
+	joker.SetJSONPrettyQuery("pretty")
+	joker.MapGet("/json/options", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, map[string]string{"html": "<b>"}
+	})
+	server := serve(t)
+
+	_, body := get(t, server.URL+"/json/options", nil)
+	if body != `{"html":"<b>"}` {
+		t.Fatalf("body %q", body)
+	}
+	if !used {
+		t.Fatal("custom codec was not used")
+	}
+	_, body = get(t, server.URL+"/json/options?pretty", nil)
+	if body != "{\n  \"html\": \"<b>\"\n}" {
+		t.Fatalf("pretty body %q", body)
+	}
+}
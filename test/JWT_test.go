@@ -0,0 +1,169 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestJWTMiddleware(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	secret := engine.JWTKey{Algorithm: "HS256", Key: []byte("top-secret")}
+	api := router.Group("/jwt")
+	api.Use(engine.JWT(engine.JWTOptions{
+		Keys:     engine.StaticKeys(secret),
+		Issuer:   "joker",
+		Audience: "api",
+		Leeway:   30 * time.Second,
+	}))
+	api.MapGet("/me", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		claims, _ := engine.RequestValue[engine.Claims](request, "claims")
+		return 200, claims.Subject()
+	})
+	server := serve(t)
+
+	sign := func(claims engine.Claims) string {
+		token, err := engine.SignJWT(claims, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	now := time.Now()
+	valid := sign(engine.Claims{"sub": "alice", "iss": "joker", "aud": []string{"web", "api"}, "exp": now.Add(-10 * time.Second).Unix()})
+	resp, body := get(t, server.URL+"/jwt/me", map[string]string{"Authorization": "Bearer " + valid})
+	if resp.StatusCode != 200 || body != `"alice"` {
+		t.Fatalf("valid token within leeway: %d %s", resp.StatusCode, body)
+	}
+
+	for name, token := range map[string]string{
+		"missing":  "",
+		"expired":  sign(engine.Claims{"iss": "joker", "aud": "api", "exp": now.Add(-time.Minute).Unix()}),
+		"audience": sign(engine.Claims{"iss": "joker", "aud": "web"}),
+		"issuer":   sign(engine.Claims{"iss": "other", "aud": "api"}),
+		"tampered": valid[:len(valid)-2] + "AA",
+		"far nbf":  sign(engine.Claims{"iss": "joker", "aud": "api", "nbf": 1e19}),
+	} {
+		resp, _ := get(t, server.URL+"/jwt/me", map[string]string{"Authorization": "Bearer " + token})
+		if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s token: %d", name, resp.StatusCode)
+		}
+	}
+	// A time that overflows nanoseconds must not wrap around into the past
+	if _, err := engine.ParseJWT(context.Background(), sign(engine.Claims{"nbf": 1e19}), engine.JWTOptions{Keys: engine.StaticKeys(secret)}); !errors.Is(err, engine.ErrTokenMalformed) {
+		t.Fatalf("overflowing nbf: %v", err)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := []map[string]string{{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())}}
+	var fetches atomic.Int32
+	var rotated atomic.Bool
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		keys := jwks
+		if rotated.Load() {
+			keys = append(keys, map[string]string{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": b64(edPublic)})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer keyServer.Close()
+
+	options := engine.JWTOptions{Keys: engine.JWKSURL(keyServer.URL, time.Hour)}
+	ecToken, _ := engine.SignJWT(engine.Claims{"sub": "ec"}, engine.JWTKey{ID: "ec-1", Algorithm: "ES256", Key: ecKey})
+	claims, err := engine.ParseJWT(context.Background(), ecToken, options)
+	if err != nil || claims.Subject() != "ec" {
+		t.Fatalf("ES256 token: %v", err)
+	}
+
+	// A token signed with a key published after the first fetch triggers a reload
+	rotated.Store(true)
+	edToken, _ := engine.SignJWT(engine.Claims{"sub": "ed"}, engine.JWTKey{ID: "ed-1", Algorithm: "EdDSA", Key: edPrivate})
+	claims, err = engine.ParseJWT(context.Background(), edToken, options)
+	if err != nil || claims.Subject() != "ed" || fetches.Load() != 2 {
+		t.Fatalf("EdDSA token after rotation: %v, %d fetches", err, fetches.Load())
+	}
+	if _, err = engine.ParseJWT(context.Background(), ecToken, engine.JWTOptions{Keys: options.Keys, Algorithms: []string{"EdDSA"}}); !errors.Is(err, engine.ErrTokenAlgorithm) {
+		t.Fatalf("disallowed algorithm: %v", err)
+	}
+}
+
+func TestJWKSUnavailable(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer keyServer.Close()
+
+	joker := engine.NewEngine()
+	joker.Init()
+	api := joker.NewRouter().Group("/jwks-down")
+	api.Use(engine.JWT(engine.JWTOptions{Keys: engine.JWKSURL(keyServer.URL, time.Hour)}))
+	api.MapGet("/me", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, "me"
+	})
+	server := serve(t)
+	token, _ := engine.SignJWT(engine.Claims{"sub": "joker"}, engine.JWTKey{ID: "k", Algorithm: "HS256", Key: []byte("secret")})
+
+	// Concurrent requests share one fetch, and a client giving up does not cancel it for the others
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.ParseJWT(gone, token, engine.JWTOptions{Keys: engine.JWKSURL(keyServer.URL, time.Hour)}); err == nil {
+		t.Fatal("cancelled request got keys")
+	}
+	results := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			resp, body := get(t, server.URL+"/jwks-down/me", map[string]string{"Authorization": "Bearer " + token})
+			results <- strconv.Itoa(resp.StatusCode) + " " + body
+		}()
+	}
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < 4; i++ {
+		result := <-results
+		if !strings.HasPrefix(result, "503 ") || strings.Contains(result, keyServer.URL) || strings.Contains(result, "Bad Gateway") {
+			t.Fatalf("key set failure answered with %s", result)
+		}
+	}
+	// One fetch for the cancelled ParseJWT's own key set, which runs on regardless, and one for the middleware
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("%d fetches, want 2", n)
+	}
+
+	// Until the retry interval has passed, later requests get the cached failure without another fetch
+	for i := 0; i < 3; i++ {
+		if resp, body := get(t, server.URL+"/jwks-down/me", map[string]string{"Authorization": "Bearer " + token}); resp.StatusCode != 503 {
+			t.Fatalf("after a failed load: %d %s", resp.StatusCode, body)
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("failed load retried on every request: %d fetches", n)
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestRateLimit(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	handler := func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, "ok"
+	}
+	strict := router.Group("/limit-strict")
+	strict.Use(engine.RateLimit(engine.RateLimitOptions{Algorithm: engine.FixedWindow, Limit: 2, Window: time.Hour}))
+	strict.MapGet("/a", handler)
+	byKey := router.Group("/limit-key")
+	byKey.Use(engine.RateLimit(engine.RateLimitOptions{
+		Algorithm: engine.SlidingWindow,
+		Limit:     1,
+		Window:    time.Hour,
+		Key:       engine.RateLimitByHeader("X-API-Key"),
+		Store:     engine.NewCacheRateLimitStore(joker.Cache),
+	}))
+	byKey.MapGet("/a", handler)
+	bucket := router.Group("/limit-bucket")
+	bucket.Use(engine.RateLimit(engine.RateLimitOptions{Limit: 1, Window: time.Hour, Burst: 3}))
+	bucket.MapGet("/a", handler)
+	server := serve(t)
+
+	for i := 0; i < 2; i++ {
+		resp, _ := get(t, server.URL+"/limit-strict/a", nil)
+		if resp.StatusCode != 200 || resp.Header.Get("RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Fatalf("request %d: %d remaining %s", i, resp.StatusCode, resp.Header.Get("RateLimit-Remaining"))
+		}
+	}
+	resp, _ := get(t, server.URL+"/limit-strict/a", nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" ||
+		resp.Header.Get("Content-Type") != "application/problem+json" || resp.Header.Get("RateLimit-Policy") != "2;w=3600" {
+		t.Fatalf("over limit: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	for _, key := range []string{"alice", "bob"} {
+		if resp, _ := get(t, server.URL+"/limit-key/a", map[string]string{"X-API-Key": key}); resp.StatusCode != 200 {
+			t.Fatalf("first request for %s: %d", key, resp.StatusCode)
+		}
+	}
+	if resp, _ := get(t, server.URL+"/limit-key/a", map[string]string{"X-API-Key": "alice"}); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second request for alice: %d", resp.StatusCode)
+	}
+	if resp, _ := get(t, server.URL+"/limit-key/a", nil); resp.StatusCode != 200 {
+		t.Fatalf("requests without a key should not be limited: %d", resp.StatusCode)
+	}
+
+	for i := 0; i < 3; i++ {
+		if resp, _ := get(t, server.URL+"/limit-bucket/a", nil); resp.StatusCode != 200 {
+			t.Fatalf("burst request %d: %d", i, resp.StatusCode)
+		}
+	}
+	if resp, _ := get(t, server.URL+"/limit-bucket/a", nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("bucket should be empty: %d", resp.StatusCode)
+	}
+}
+
+type ttlStore struct {
+	engine.RateLimitStore
+	ttl time.Duration
+}
+
+func (store *ttlStore) Update(key string, ttl time.Duration, update func(state *engine.RateLimitState)) {
+	store.ttl = ttl
+	store.RateLimitStore.Update(key, ttl, update)
+}
+
+func TestRateLimitStateOutlivesRefill(t *testing.T) {
+	store := &ttlStore{RateLimitStore: engine.NewMemoryRateLimitStore()}
+	limit := engine.RateLimit(engine.RateLimitOptions{Algorithm: engine.TokenBucket, Limit: 2, Window: time.Second, Burst: 10, Store: store, Key: func(ctx *engine.JokerContex) string { return "k" }})
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.Use(limit)
+	joker.MapGet("/limit-ttl", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, nil
+	})
+	server := serve(t)
+	get(t, server.URL+"/limit-ttl", nil)
+	if store.ttl != 5*time.Second {
+		t.Fatalf("ttl %v, want the 5s a 10 token bucket takes to refill at 2/s", store.ttl)
+	}
+}
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestRecovery(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/recovery")
+	reported := make(chan string, 1)
+	api.Use(engine.RecoveryWithReporter(func(ctx *engine.JokerContex, recovered interface{}, stack []byte) {
+		reported <- ctx.Request.URL.Path + " " + recovered.(string) + " " + string(stack)
+	}))
+	api.MapGet("/panic", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		panic("boom")
+	})
+	server := serve(t)
+
+	resp, body := get(t, server.URL+"/recovery/panic", nil)
+	if resp.StatusCode != 500 || resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if strings.Contains(body, "boom") {
+		t.Fatalf("panic value leaked: %s", body)
+	}
+	report := <-reported
+	if !strings.HasPrefix(report, "/recovery/panic boom ") || !strings.Contains(report, "Recovery_test.go") {
+		t.Fatalf("report %q", report)
+	}
+}
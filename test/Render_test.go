@@ -0,0 +1,99 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+type renderUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestRenderNegotiation(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.RegisterRenderer("text/plain", engine.RendererFunc(func(w io.Writer, r *http.Request, response interface{}) error {
+		_, err := io.WriteString(w, "plain")
+		return err
+	}))
+	joker.MapGet("/render/users", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, []renderUser{{Name: "joker", Age: 18}}
+	})
+	joker.MapGet("/render/map", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, map[string]string{"message": "hi"}
+	})
+	joker.MapGet("/render/badkeys", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, map[string]string{"a><evil/><b": "v", "1st key": "w"}
+	})
+	server := serve(t)
+
+	cases := []struct {
+		path, accept, contentType, body string
+		status                          int
+	}{
+		{"/render/users", "", "application/json", `[{"name":"joker","age":18}]`, 200},
+		{"/render/users", "text/csv", "text/csv", "name,age\njoker,18\n", 200},
+		{"/render/users", "application/yaml", "application/yaml", "-\n  name: joker\n  age: 18\n", 200},
+		{"/render/map", "application/xml", "application/xml", "<response><message>hi</message></response>", 200},
+		{"/render/map", "text/csv, application/json;q=0.5", "application/json", `{"message":"hi"}`, 200},
+		{"/render/map", "text/plain", "text/plain", "plain", 200},
+		{"/render/map", "image/png", "", "", http.StatusNotAcceptable},
+		// Browsers only reach JSON through */*, they still get it rather than XML
+		{"/render/map", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/json", `{"message":"hi"}`, 200},
+		// Legacy clients that add a low-q */* still get the type they named
+		{"/render/map", "application/xml, */*;q=0.1", "application/xml", "<response><message>hi</message></response>", 200},
+		{"/render/map", "application/xml, text/xml, */*; q=0.01", "application/xml", "<response><message>hi</message></response>", 200},
+		// On equal q, JSON reached through the wildcard wins
+		{"/render/users", "text/csv;q=0.5, */*;q=0.5", "application/json", `[{"name":"joker","age":18}]`, 200},
+		{"/render/badkeys", "application/xml", "", "", http.StatusNotAcceptable},
+		{"/render/badkeys", "application/xml, application/json;q=0.5", "application/json", `"1st key":"w"`, 200},
+	}
+	for _, c := range cases {
+		resp, body := get(t, server.URL+c.path, map[string]string{"Accept": c.accept})
+		if resp.StatusCode != c.status {
+			t.Fatalf("%s %q: status %d, want %d", c.path, c.accept, resp.StatusCode, c.status)
+		}
+		if resp.Header.Get("Content-Type") != c.contentType && c.contentType != "" {
+			t.Fatalf("%s %q: content type %q, want %q", c.path, c.accept, resp.Header.Get("Content-Type"), c.contentType)
+		}
+		if !strings.Contains(body, c.body) {
+			t.Fatalf("%s %q: body %q, want %q", c.path, c.accept, body, c.body)
+		}
+	}
+}
+
+func TestRenderMsgPack(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.MapGet("/render/msgpack", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 200, map[string]interface{}{"a": 1, "b": true}
+	})
+	server := serve(t)
+	_, body := get(t, server.URL+"/render/msgpack", map[string]string{"Accept": "application/msgpack"})
+	want := "\x82\xa1a\x01\xa1b\xc3"
+	if body != want {
+		t.Fatalf("body %x, want %x", body, want)
+	}
+}
+
+func TestRenderCyclicValue(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.MapGet("/render/cyclic", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		cyclic := map[string]interface{}{}
+		cyclic["self"] = cyclic
+		return 200, cyclic
+	})
+	server := serve(t)
+	for _, accept := range []string{"application/yaml", "application/msgpack", "application/xml", "application/json"} {
+		if resp, _ := get(t, server.URL+"/render/cyclic", map[string]string{"Accept": accept}); resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("%s: status %d", accept, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestSSEBrokerReplay(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	broker := engine.NewSSEBroker(10)
+	joker.MapSSE("/sse/news", func(request *http.Request, stream *engine.SSEStream) {
+		broker.Serve(stream, "news")
+	})
+	server := serve(t)
+
+	post, err := http.Post(server.URL+"/sse/news", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	if post.StatusCode != http.StatusMethodNotAllowed || post.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("post: %d %q", post.StatusCode, post.Header.Get("Content-Type"))
+	}
+
+	broker.Publish("news", engine.SSEEvent{Event: "update", ID: "1", Data: "first"})
+	broker.Publish("news", engine.SSEEvent{Event: "update", ID: "2", Data: map[string]int{"n": 2}})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/sse/news", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("content type %q", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	if got := readEvent(); got != "id: 2\nevent: update\ndata: {\"n\":2}\n" {
+		t.Fatalf("replayed event %q", got)
+	}
+	for broker.Subscribers("news") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	broker.Publish("news", engine.SSEEvent{Event: "update", ID: "3", Data: "line1\nline2"})
+	if got := readEvent(); got != "id: 3\nevent: update\ndata: line1\ndata: line2\n" {
+		t.Fatalf("live event %q", got)
+	}
+	// A lone \r must not start a new field
+	broker.Publish("news", engine.SSEEvent{ID: "4", Data: "hello\revent: admin\rdata: forged"})
+	if got := readEvent(); got != "id: 4\ndata: hello\ndata: event: admin\ndata: data: forged\n" {
+		t.Fatalf("injected event %q", got)
+	}
+}
+
+func TestSSEShutdown(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	finished := make(chan struct{})
+	joker.MapSSE("/sse/shutdown", func(request *http.Request, stream *engine.SSEStream) {
+		<-stream.Done()
+		close(finished)
+	})
+	server := serve(t)
+	resp, err := http.Get(server.URL + "/sse/shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	joker.Shutdown(context.Background())
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream was not closed on shutdown")
+	}
+}
+
+func TestSSEOutlivesRequestTimeout(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetRequestTimeout(50 * time.Millisecond)
+	joker.MapSSE("/sse/slow", func(request *http.Request, stream *engine.SSEStream) {
+		select {
+		case <-time.After(150 * time.Millisecond):
+			stream.Send("tick", "", "late")
+		case <-stream.Done():
+		}
+	})
+	server := serve(t)
+	resp, err := http.Get(server.URL + "/sse/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "event: tick\n" {
+		t.Fatalf("stream ended by the engine-wide timeout: %q, %v", line, err)
+	}
+}
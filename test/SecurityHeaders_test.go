@@ -0,0 +1,65 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte(`<script nonce="{{cspNonce}}"></script>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetServerHeader("")
+	if err := joker.LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	router := joker.NewRouter()
+	strict := router.Group("/secure-strict")
+	strict.Use(engine.SecurityHeaders(engine.StrictSecurityHeaders()))
+	strict.MapGet("/page", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return engine.GetContext(request).HTML(200, "page.html", nil)
+	})
+	relaxed := router.Group("/secure-relaxed")
+	relaxed.Use(engine.SecurityHeaders(engine.RelaxedSecurityHeaders()))
+	relaxed.MapGet("/missing", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return 404, nil
+	})
+	server := serve(t)
+
+	resp, body := get(t, server.URL+"/secure-strict/page", nil)
+	csp := resp.Header.Get("Content-Security-Policy")
+	nonce := strings.TrimSuffix(strings.TrimPrefix(body, `<script nonce="`), `"></script>`)
+	if nonce == "" || nonce == body || !strings.Contains(csp, "'nonce-"+nonce+"'") {
+		t.Fatalf("nonce %q not in policy %q", nonce, csp)
+	}
+	for name, want := range map[string]string{
+		"X-Content-Type-Options":       "nosniff",
+		"Referrer-Policy":              "no-referrer",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "require-corp",
+		"Server":                       "",
+		// HSTS is only sent over HTTPS
+		"Strict-Transport-Security": "",
+	} {
+		if got := resp.Header.Get(name); got != want {
+			t.Fatalf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if _, again := get(t, server.URL+"/secure-strict/page", nil); again == body {
+		t.Fatal("nonce reused across requests")
+	}
+
+	resp, _ = get(t, server.URL+"/secure-relaxed/missing", nil)
+	if resp.StatusCode != 404 || resp.Header.Get("Referrer-Policy") != "strict-origin-when-cross-origin" || resp.Header.Get("Cross-Origin-Embedder-Policy") != "" {
+		t.Fatalf("relaxed headers on error: %d %v", resp.StatusCode, resp.Header)
+	}
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestSessions(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	fileStore, err := engine.NewFileSessionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]engine.SessionStore{
+		"memory": nil,
+		"file":   fileStore,
+		"cookie": engine.NewCookieSessionStore(engine.NewKeyring([]byte("cookie secret"))),
+	}
+	for name, store := range stores {
+		group := router.Group("/session-" + name)
+		group.Use(engine.Sessions(engine.SessionOptions{Store: store, CookieName: "sid-" + name}))
+		group.MapGet("/login", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			session := engine.GetContext(request).Session()
+			session.Regenerate()
+			session.Set("user", params.Get("user"))
+			session.Set("visits", 0)
+			return 200, session.ID()
+		})
+		group.MapGet("/visit", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			session := engine.GetContext(request).Session()
+			user, _ := engine.SessionValue[string](session, "user")
+			visits, _ := engine.SessionValue[int](session, "visits")
+			session.Set("visits", visits+1)
+			return 200, user + ":" + strconv.Itoa(visits+1)
+		})
+		group.MapGet("/peek", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			user, _ := engine.SessionValue[string](engine.GetContext(request).Session(), "user")
+			return 200, user
+		})
+		group.MapGet("/logout", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+			engine.GetContext(request).Session().Destroy()
+			return 200, nil
+		})
+	}
+	server := serve(t)
+
+	for name := range stores {
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		fetch := func(path string) (*http.Response, string) {
+			resp, err := client.Get(server.URL + "/session-" + name + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp, string(body)
+		}
+
+		if resp, _ := fetch("/peek"); len(resp.Cookies()) != 0 {
+			t.Fatalf("%s: unmodified session set a cookie", name)
+		}
+		fetch("/login?user=ann")
+		firstCookie := jar.Cookies(mustParse(server.URL))[0].Value
+		if _, body := fetch("/visit"); body != `"ann:1"` {
+			t.Fatalf("%s: first visit %s", name, body)
+		}
+		if _, body := fetch("/visit"); body != `"ann:2"` {
+			t.Fatalf("%s: second visit %s", name, body)
+		}
+		fetch("/login?user=ann")
+		if name != "cookie" && jar.Cookies(mustParse(server.URL))[0].Value == firstCookie {
+			t.Fatalf("%s: login did not regenerate the session ID", name)
+		}
+		fetch("/logout")
+		if _, body := fetch("/peek"); body != `""` {
+			t.Fatalf("%s: session survived logout: %s", name, body)
+		}
+	}
+
+	// A tampered cookie is treated as no session at all
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/session-cookie/peek", nil)
+	req.AddCookie(&http.Cookie{Name: "sid-cookie", Value: strings.Repeat("A", 80)})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("tampered cookie: %d", resp.StatusCode)
+	}
+}
+
+func mustParse(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestSessionsDefaultStoreConcurrent(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	group := joker.NewRouter().Group("/session-concurrent")
+	group.Use(engine.Sessions(engine.SessionOptions{}))
+	group.MapGet("/set", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		engine.GetContext(request).Session().Set("n", 1)
+		return 200, nil
+	})
+	server := serve(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL + "/session-concurrent/set")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if len(resp.Cookies()) == 0 {
+				t.Error("no session cookie")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,57 @@
+package test
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestTemplateLayoutAndReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("layouts/base.html", `<html>{{block "content" .}}{{end}}{{template "partials/footer.html"}}</html>`)
+	write("partials/footer.html", `<footer>joker</footer>`)
+	write("index.html", `{{define "content"}}<h1>{{shout .}}</h1>{{end}}`)
+
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetDevMode(true)
+	joker.AddTemplateFuncs(template.FuncMap{"shout": strings.ToUpper})
+	if err := joker.LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	joker.SetTemplateLayout("layouts/base.html")
+	joker.MapGet("/template/index", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		return engine.GetContext(request).HTML(200, "index.html", "hi <b>")
+	})
+	server := serve(t)
+
+	resp, body := get(t, server.URL+"/template/index", nil)
+	if resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("content type %q", resp.Header.Get("Content-Type"))
+	}
+	if body != "<html><h1>HI &lt;B&gt;</h1><footer>joker</footer></html>" {
+		t.Fatalf("body %q", body)
+	}
+
+	write("index.html", `{{define "content"}}<h2>{{.}}</h2>{{end}}`)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "index.html"), future, future)
+	_, body = get(t, server.URL+"/template/index", nil)
+	if body != "<html><h2>hi &lt;b&gt;</h2><footer>joker</footer></html>" {
+		t.Fatalf("reloaded body %q", body)
+	}
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+	"github.com/jeanhua/jokerhttp/utils"
+)
+
+func TestTimeoutCancelsWork(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	slow := router.Group("/timeout")
+	slow.Use(engine.Timeout(50 * time.Millisecond))
+	loaderCancelled := make(chan struct{})
+	slow.MapGet("/cache", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		ctx := engine.GetContext(request)
+		value, err := joker.Cache.GetOrLoad(ctx.Context(), "timeout:slow", utils.AbsoluteTimeFromNow(time.Minute), func(loadCtx context.Context) (interface{}, error) {
+			<-loadCtx.Done()
+			close(loaderCancelled)
+			return nil, loadCtx.Err()
+		})
+		if err != nil {
+			return 500, nil
+		}
+		return 200, value
+	})
+	slow.MapReverseProxy("/proxy", upstream.URL)
+	server := serve(t)
+
+	start := time.Now()
+	resp, _ := get(t, server.URL+"/timeout/cache", nil)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("cache status %d", resp.StatusCode)
+	}
+	select {
+	case <-loaderCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("loader context was not cancelled")
+	}
+	resp, _ = get(t, server.URL+"/timeout/proxy", nil)
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("proxy status %d", resp.StatusCode)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("deadline did not stop the work")
+	}
+
+	value, err := joker.Cache.GetOrLoad(context.Background(), "timeout:fast", utils.AbsoluteTimeFromNow(time.Minute), func(context.Context) (interface{}, error) {
+		return "loaded", nil
+	})
+	if err != nil || value != "loaded" {
+		t.Fatalf("load: %v %v", value, err)
+	}
+	if cached, ok := joker.Cache.TryGet("timeout:fast"); !ok || cached != "loaded" {
+		t.Fatal("loaded value was not cached")
+	}
+}
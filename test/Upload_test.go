@@ -0,0 +1,99 @@
+package test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func multipartBody(t *testing.T, fields map[string]string, files map[string][]byte) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	for name, content := range files {
+		part, err := writer.CreateFormFile(name, name+".bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+	return &body, writer.FormDataContentType()
+}
+
+func TestMapUpload(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	tempDir := t.TempDir()
+	spooled := false
+	joker.MapUpload("/upload/image", engine.UploadOptions{
+		TempDir:         tempDir,
+		MemoryThreshold: 1024,
+		MaxFileSize:     64 << 10,
+		AllowedTypes:    []string{"image/*"},
+	}, func(request *http.Request, form *engine.UploadForm, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		file := form.File("avatar")
+		if entries, _ := os.ReadDir(tempDir); !file.InMemory() && len(entries) == 1 {
+			spooled = true
+		}
+		return 200, map[string]interface{}{"title": form.Values.Get("title"), "type": file.ContentType, "size": file.Size}
+	})
+	joker.Map("/upload/formfile", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		file, header, err := engine.GetContext(request).FormFile("doc")
+		if err != nil {
+			return 400, err.Error()
+		}
+		defer file.Close()
+		content, _ := io.ReadAll(file)
+		return 200, header.Filename + ":" + string(content)
+	})
+	server := serve(t)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 4096)...)
+	body, contentType := multipartBody(t, map[string]string{"title": "me"}, map[string][]byte{"avatar": png})
+	resp, err := http.Post(server.URL+"/upload/image", contentType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(data) != `{"size":4104,"title":"me","type":"image/png"}` {
+		t.Fatalf("upload: %d %s", resp.StatusCode, data)
+	}
+	if !spooled {
+		t.Fatal("large file was not spooled to disk")
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Fatal("temp file was not removed after the request")
+	}
+
+	body, contentType = multipartBody(t, nil, map[string][]byte{"avatar": []byte("plain text")})
+	resp, _ = http.Post(server.URL+"/upload/image", contentType, body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("disallowed type: %d", resp.StatusCode)
+	}
+
+	body, contentType = multipartBody(t, nil, map[string][]byte{"avatar": append(png, bytes.Repeat([]byte{1}, 64<<10)...)})
+	resp, _ = http.Post(server.URL+"/upload/image", contentType, body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized file: %d", resp.StatusCode)
+	}
+
+	body, contentType = multipartBody(t, nil, map[string][]byte{"doc": []byte("hello")})
+	resp, _ = http.Post(server.URL+"/upload/formfile", contentType, body)
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != `"doc.bin:hello"` {
+		t.Fatalf("form file: %s", data)
+	}
+}
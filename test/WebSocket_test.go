@@ -0,0 +1,133 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, serverURL, path, extensions string) (*wsClient, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	request := "GET " + path + " HTTP/1.1\r\nHost: " + strings.TrimPrefix(serverURL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if extensions != "" {
+		request += "Sec-WebSocket-Extensions: " + extensions + "\r\n"
+	}
+	conn.Write([]byte(request + "\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{conn: conn, reader: reader}, resp
+}
+
+func (c *wsClient) writeFrame(b0 byte, payload []byte) {
+	frame := []byte{b0, 0x80 | byte(len(payload))}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+func (c *wsClient) readFrame(t *testing.T) (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(c.reader, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	io.ReadFull(c.reader, payload)
+	return header[0], payload
+}
+
+func TestWebSocketEchoAndRooms(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	joker.SetWebSocketOptions(engine.WebSocketOptions{EnableCompression: true})
+	hub := engine.NewWebSocketHub()
+	router := joker.NewRouter()
+	group := router.Group("/ws")
+	group.Use(func(ctx *engine.JokerContex) {
+		if ctx.Request.URL.Query().Get("token") != "secret" {
+			ctx.AbortWithStatus(401)
+			return
+		}
+		ctx.Next()
+	})
+	group.MapWebSocket("/chat", func(request *http.Request, conn *engine.WebSocketConn) {
+		hub.Join("lobby", conn)
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			hub.BroadcastRoom("lobby", messageType, data, nil)
+		}
+	})
+	server := serve(t)
+
+	if _, resp := dialWebSocket(t, server.URL, "/ws/chat", ""); resp.StatusCode != 401 {
+		t.Fatalf("unauthenticated upgrade status %d", resp.StatusCode)
+	}
+
+	client, resp := dialWebSocket(t, server.URL, "/ws/chat?token=secret", "permessage-deflate; client_max_window_bits")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("accept %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	if !strings.HasPrefix(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("extensions %q", resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
+
+	// A fragmented text message with a ping in between
+	client.writeFrame(0x01, []byte("hel"))
+	client.writeFrame(0x89, []byte("p"))
+	client.writeFrame(0x80, []byte("lo"))
+	b0, payload := client.readFrame(t)
+	if b0 != 0x8a || string(payload) != "p" {
+		t.Fatalf("expected pong, got %x %q", b0, payload)
+	}
+	b0, payload = client.readFrame(t)
+	if b0 != 0xc1 {
+		t.Fatalf("expected compressed text frame, got %x", b0)
+	}
+	inflated, _ := io.ReadAll(flate.NewReader(bytes.NewReader(append(payload, 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff))))
+	if string(inflated) != "hello" {
+		t.Fatalf("echo %q", inflated)
+	}
+
+	// Invalid UTF-8 closes with 1007
+	client.writeFrame(0x81, []byte{0xff})
+	b0, payload = client.readFrame(t)
+	if b0 != 0x88 || binary.BigEndian.Uint16(payload) != engine.CloseInvalidFramePayloadData {
+		t.Fatalf("expected close 1007, got %x %v", b0, payload)
+	}
+}
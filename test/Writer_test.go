@@ -0,0 +1,48 @@
+package test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeanhua/jokerhttp/engine"
+)
+
+func TestResponseWriterState(t *testing.T) {
+	joker := engine.NewEngine()
+	joker.Init()
+	router := joker.NewRouter()
+	api := router.Group("/writer")
+	type record struct {
+		status  int
+		size    int64
+		written bool
+	}
+	records := make(chan record, 1)
+	api.Use(func(ctx *engine.JokerContex) {
+		ctx.BeforeWrite(func(status int) {
+			ctx.ResponseWriter.Header().Set("X-Status-Seen", http.StatusText(status))
+		})
+		ctx.Next()
+		records <- record{ctx.Status(), ctx.Size(), ctx.Written()}
+	})
+	api.MapGet("/twice", func(request *http.Request, params url.Values, setHeaders func(key, value string)) (status int, response interface{}) {
+		ctx := engine.GetContext(request)
+		if err := http.NewResponseController(ctx.ResponseWriter).SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+			t.Errorf("ResponseController: %v", err)
+		}
+		ctx.ResponseWriter.WriteHeader(http.StatusCreated)
+		ctx.ResponseWriter.Write([]byte("hello"))
+		return http.StatusTeapot, nil
+	})
+	server := serve(t)
+
+	resp, body := get(t, server.URL+"/writer/twice", nil)
+	if resp.StatusCode != http.StatusCreated || body != "hello" || resp.Header.Get("X-Status-Seen") != "Created" {
+		t.Fatalf("status %d, body %q, header %q", resp.StatusCode, body, resp.Header.Get("X-Status-Seen"))
+	}
+	if got := <-records; got != (record{http.StatusCreated, 5, true}) {
+		t.Fatalf("recorded %+v", got)
+	}
+}